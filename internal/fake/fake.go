@@ -0,0 +1,66 @@
+// Package fake links the systray package with the fake backend of the systraytest package.
+// The functions without a body are implemented by the systray package through go:linkname,
+// so that the fake backend is only linked into the binaries importing systraytest. Trays are
+// passed as interface{} holding a *systray.Tray, nil meaning the default tray.
+package fake
+
+// Item is the recorded state of a menu item or a separator
+type Item struct {
+	ID        uint32
+	Separator bool
+
+	Title, Tooltip string
+	Icon           []byte
+	IconName       string
+	Disabled       bool
+	Hidden         bool
+	Checkable      bool
+	Checked        bool
+	Items          []Item
+}
+
+// Tray is the recorded state of a tray
+type Tray struct {
+	Icon, AttentionIcon, OverlayIcon             []byte
+	IconName, AttentionIconName, OverlayIconName string
+	IconThemePath                                string
+	Title, Tooltip                               string
+	// Status is a systray.Status
+	Status int
+	Hidden bool
+	// MenuOpen is true while the top level menu is opened by Event
+	MenuOpen bool
+	Menu     []Item
+}
+
+// Buttons tapping the icon
+const (
+	Primary = iota
+	Secondary
+	Middle
+)
+
+// Start replaces the platform backend by one recording the state of the trays, then calls
+// onReady and returns once it did. It fails if the backend is already replaced.
+func Start(onReady, onExit func()) error
+
+// Stop quits unless Quit was called and restores the platform backend.
+func Stop()
+
+// Quitted returns whether Quit was called since Start.
+func Quitted() bool
+
+// State returns the recorded state of the tray.
+func State(tray interface{}) Tray
+
+// Event dispatches an event of the kind, a systray.MenuEventKind, on the item with the ID or on
+// the top level menu for 0. It returns once the functions notified returned.
+func Event(tray interface{}, id uint32, kind int)
+
+// Tap calls the function handling a click on the icon with the button at the position, and
+// returns false if there is none.
+func Tap(tray interface{}, button, x, y int) bool
+
+// Scroll calls the function handling a scroll over the icon, orientation being a
+// systray.Orientation, and returns false if there is none.
+func Scroll(tray interface{}, delta, orientation int) bool
@@ -0,0 +1,2 @@
+// This file lets the functions of fake.go be declared without a body, they are implemented by
+// the systray package.
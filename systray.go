@@ -2,8 +2,9 @@
 package systray
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
 	"runtime"
 	"sync"
 	"sync/atomic"
@@ -11,17 +12,115 @@ import (
 
 var (
 	systrayReady, systrayExit func()
-	tappedLeft, tappedRight   func()
 	systrayExitCalled         bool
 	menuItems                 = make(map[uint32]*MenuItem)
 	menuItemsLock             sync.RWMutex
 
 	initialMenuBuilt sync.WaitGroup
 	currentID        atomic.Uint32
-	quitOnce         sync.Once
+	// quitCalled is set by Quit until Run or Register is called again
+	quitCalled atomic.Bool
 
-	// TrayOpenedCh receives an entry each time the system tray menu is opened.
+	// TrayOpenedCh receives an entry each time the menu of the default tray is opened.
 	TrayOpenedCh = make(chan struct{})
+	// TrayClosedCh receives an entry each time the menu of the default tray is closed.
+	TrayClosedCh = make(chan struct{})
+)
+
+var (
+	// ErrNotReady is returned when the tray is changed before it is ready and the change cannot
+	// be kept until then, see Run.
+	ErrNotReady = errors.New("systray not ready yet")
+	// ErrInvalidIcon is returned when icon data cannot be decoded.
+	ErrInvalidIcon = errors.New("invalid icon data")
+)
+
+// Status describes how prominently the tray icon should be presented, see SetStatus.
+type Status int
+
+const (
+	// StatusActive is the default status, the icon is shown normally.
+	StatusActive Status = iota
+	// StatusPassive indicates that the application is idle. Hosts may hide the icon.
+	StatusPassive
+	// StatusNeedsAttention indicates that the application needs the user's attention.
+	StatusNeedsAttention
+)
+
+// String returns the name of the status as defined by the StatusNotifierItem specification.
+func (s Status) String() string {
+	switch s {
+	case StatusPassive:
+		return "Passive"
+	case StatusNeedsAttention:
+		return "NeedsAttention"
+	default:
+		return "Active"
+	}
+}
+
+// Orientation is the direction of a scroll event, see SetOnScroll.
+type Orientation int
+
+const (
+	// OrientationVertical is reported for the usual mouse wheel movement.
+	OrientationVertical Orientation = iota
+	// OrientationHorizontal is reported for tilting wheels and sideways trackpad movement.
+	OrientationHorizontal
+)
+
+// MenuEventKind is the kind of a MenuEvent.
+type MenuEventKind int
+
+const (
+	// MenuEventClicked is sent when the menu item is clicked.
+	MenuEventClicked MenuEventKind = iota
+	// MenuEventHovered is sent when the menu item is highlighted.
+	MenuEventHovered
+	// MenuEventOpened is sent when the sub-menu of the menu item is opened.
+	MenuEventOpened
+	// MenuEventClosed is sent when the sub-menu of the menu item is closed.
+	MenuEventClosed
+)
+
+// String returns the name of the event kind.
+func (k MenuEventKind) String() string {
+	switch k {
+	case MenuEventHovered:
+		return "hovered"
+	case MenuEventOpened:
+		return "opened"
+	case MenuEventClosed:
+		return "closed"
+	default:
+		return "clicked"
+	}
+}
+
+// MenuEvent describes an interaction with a menu item, see MenuItem.Events.
+type MenuEvent struct {
+	Kind MenuEventKind
+	// Timestamp is the time of the event in milliseconds as reported by the host, from an
+	// unspecified origin. Repeated events can be recognised by their equal timestamps.
+	// It is 0 when unknown.
+	Timestamp uint32
+	// Modifiers are the keyboard modifiers held during the event, always 0 on Linux where
+	// hosts do not report them.
+	Modifiers Modifier
+}
+
+// Modifier is a set of keyboard modifiers used in menu item shortcuts, see MenuItem.SetShortcut.
+type Modifier int
+
+const (
+	// ModifierControl is the Control key.
+	ModifierControl Modifier = 1 << iota
+	// ModifierShift is the Shift key.
+	ModifierShift
+	// ModifierAlt is the Alt key, Option on macOS.
+	ModifierAlt
+	// ModifierSuper is the Command key on macOS and the Windows key on other platforms.
+	ModifierSuper
 )
 
 // This helper function allows us to call systrayExit only once,
@@ -42,6 +141,13 @@ func init() {
 type MenuItem struct {
 	// ClickedCh is the channel which will be notified when the menu item is clicked
 	ClickedCh chan struct{}
+	// OpenedCh is the channel which will be notified when the sub-menu of the menu item is opened
+	OpenedCh chan struct{}
+	// ClosedCh is the channel which will be notified when the sub-menu of the menu item is closed
+	ClosedCh chan struct{}
+	// HoveredCh is the channel which will be notified when the menu item is highlighted, by
+	// the pointer or the keyboard
+	HoveredCh chan struct{}
 
 	// id uniquely identify a menu item, not supposed to be modified
 	id uint32
@@ -55,8 +161,29 @@ type MenuItem struct {
 	checked bool
 	// has the menu item a checkbox (Linux)
 	isCheckable bool
+	// radioGroup is the name of the group of mutually exclusive items this item belongs to, if any
+	radioGroup string
+	// shortcutKey and shortcutModifiers describe the keyboard shortcut displayed next to the title
+	shortcutKey       string
+	shortcutModifiers Modifier
+	// hidden menu item is not shown in the menu
+	hidden bool
+	// aboutToShow is called before the sub-menu of the item is shown
+	aboutToShow func()
+	// events receives the events of the item once Events has been called
+	events chan MenuEvent
+	// onClick is called by the dispatcher goroutine for each click
+	onClick func()
+	// clicks holds the clicks waiting to be received from ClickedCh, see SetClickQueue
+	clicks *clickQueue
+	// sendLock is held while sending a queued click
+	sendLock sync.Mutex
+	// removed is closed when the item is removed
+	removed chan struct{}
 	// parent item, for sub menus
 	parent *MenuItem
+	// tray is the tray showing the item
+	tray *Tray
 }
 
 func (item *MenuItem) String() string {
@@ -66,10 +193,14 @@ func (item *MenuItem) String() string {
 	return fmt.Sprintf("MenuItem[%d, parent %d, %q]", item.id, item.parent.id, item.title)
 }
 
-// newMenuItem returns a populated MenuItem object
-func newMenuItem(title string, tooltip string, parent *MenuItem) *MenuItem {
+// newMenuItem returns a populated MenuItem object shown by t
+func newMenuItem(t *Tray, title string, tooltip string, parent *MenuItem) *MenuItem {
 	item := &MenuItem{
 		ClickedCh:   make(chan struct{}),
+		OpenedCh:    make(chan struct{}),
+		ClosedCh:    make(chan struct{}),
+		HoveredCh:   make(chan struct{}),
+		removed:     make(chan struct{}),
 		id:          currentID.Add(1),
 		title:       title,
 		tooltip:     tooltip,
@@ -77,15 +208,83 @@ func newMenuItem(title string, tooltip string, parent *MenuItem) *MenuItem {
 		checked:     false,
 		isCheckable: false,
 		parent:      parent,
+		tray:        t,
 	}
 
 	menuItemsLock.Lock()
 	menuItems[item.id] = item
+	t.insertMenuID(item.parentId(), item.id, -1)
 	menuItemsLock.Unlock()
 
 	return item
 }
 
+// newSeparatorID returns the ID of a new separator inserted in the menu of parent at index,
+// appending it if index is out of range
+func (t *Tray) newSeparatorID(parent uint32, index int) uint32 {
+	id := currentID.Add(1)
+	menuItemsLock.Lock()
+	t.insertMenuID(parent, id, index)
+	menuItemsLock.Unlock()
+	return id
+}
+
+// insertMenuID inserts id in the menu of parent at index, appending it if index is out of range.
+// menuItemsLock must be held.
+func (t *Tray) insertMenuID(parent, id uint32, index int) {
+	order := t.menuOrder[parent]
+	if index < 0 || index >= len(order) {
+		t.menuOrder[parent] = append(order, id)
+		return
+	}
+	order = append(order, 0)
+	copy(order[index+1:], order[index:])
+	order[index] = id
+	t.menuOrder[parent] = order
+}
+
+// removeMenuID removes id from the menu of parent. menuItemsLock must be held.
+func (t *Tray) removeMenuID(parent, id uint32) {
+	order := t.menuOrder[parent]
+	for i, other := range order {
+		if other == id {
+			t.menuOrder[parent] = append(order[:i], order[i+1:]...)
+			return
+		}
+	}
+}
+
+// menuIndex returns the position of id in the menu of parent, or -1 if it isn't part of it
+func (t *Tray) menuIndex(parent, id uint32) int {
+	menuItemsLock.RLock()
+	defer menuItemsLock.RUnlock()
+	for i, other := range t.menuOrder[parent] {
+		if other == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// menuIndices returns the index of each entry of the menu of parent, keyed by ID
+func (t *Tray) menuIndices(parent uint32) map[uint32]int {
+	menuItemsLock.RLock()
+	defer menuItemsLock.RUnlock()
+	indices := make(map[uint32]int, len(t.menuOrder[parent]))
+	for i, id := range t.menuOrder[parent] {
+		indices[id] = i
+	}
+	return indices
+}
+
+// parentId returns the ID of the parent item, or 0 for top level items
+func (item *MenuItem) parentId() uint32 {
+	if item.parent != nil {
+		return item.parent.id
+	}
+	return 0
+}
+
 // Run initializes GUI and starts the event loop, then invokes the onReady
 // callback. It blocks until systray.Quit() is called.
 func Run(onReady, onExit func()) {
@@ -95,6 +294,55 @@ func Run(onReady, onExit func()) {
 	nativeLoop()
 }
 
+// Option configures RunContext.
+type Option func(*runOptions)
+
+type runOptions struct {
+	onReady, onExit func()
+	// native holds the options specific to the platform
+	native nativeOptions
+}
+
+// WithOnReady sets the function called once the tray is ready, as the onReady callback of Run.
+func WithOnReady(f func()) Option {
+	return func(o *runOptions) {
+		o.onReady = f
+	}
+}
+
+// WithOnExit sets the function called when the tray quits, as the onExit callback of Run.
+func WithOnExit(f func()) Option {
+	return func(o *runOptions) {
+		o.onExit = f
+	}
+}
+
+// RunContext is like Run but it returns once ctx is cancelled or Quit is called. Rather than
+// being only logged, failures to start the tray are returned, such as DBus being unavailable
+// on Linux. The onExit callback is still called then if onReady was.
+// It returns nil once the tray quits.
+func RunContext(ctx context.Context, opts ...Option) error {
+	var o runOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	setInternalLoop(true)
+	if err := register(o); err != nil {
+		return err
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			Quit()
+		case <-stop:
+		}
+	}()
+	return nativeRun()
+}
+
 // RunWithExternalLoop allows the system tray module to operate with other toolkits.
 // The returned start and end functions should be called by the toolkit when the application has started and will end.
 func RunWithExternalLoop(onReady, onExit func()) (start, end func()) {
@@ -112,19 +360,41 @@ func RunWithExternalLoop(onReady, onExit func()) (start, end func()) {
 // To overcome some OS weirdness, On macOS versions before Catalina, calling
 // this does exactly the same as Run().
 func Register(onReady func(), onExit func()) {
+	if err := register(runOptions{onReady: onReady, onExit: onExit}); err != nil {
+		logError("failed to register", "error", err)
+	}
+}
+
+func register(o runOptions) error {
+	cancel := setCallbacks(o.onReady, o.onExit)
+	if err := registerSystray(o.native); err != nil {
+		cancel()
+		return err
+	}
+	return nil
+}
+
+// setCallbacks prepares the functions called once the tray is ready and when it quits.
+// The returned function releases onReady without calling it when the tray fails to start.
+func setCallbacks(onReady, onExit func()) (cancel func()) {
+	cancel = func() {}
 	if onReady == nil {
 		systrayReady = func() {}
 	} else {
 		// Run onReady on separate goroutine to avoid blocking event loop
-		readyCh := make(chan interface{})
+		readyCh := make(chan bool, 1)
 		initialMenuBuilt.Add(1)
 		go func() {
-			<-readyCh
-			onReady()
+			if <-readyCh {
+				onReady()
+			}
 			initialMenuBuilt.Done()
 		}()
 		systrayReady = func() {
-			close(readyCh)
+			readyCh <- true
+		}
+		cancel = func() {
+			readyCh <- false
 		}
 	}
 	// unlike onReady, onExit runs in the event loop to make sure it has time to
@@ -134,11 +404,17 @@ func Register(onReady func(), onExit func()) {
 	}
 	systrayExit = onExit
 	systrayExitCalled = false
-	registerSystray()
+	quitCalled.Store(false)
+	return cancel
 }
 
-// ResetMenu will remove all menu items
-func ResetMenu() {
+// ResetMenu will remove all menu items of the tray
+func (t *Tray) ResetMenu() {
+	t.forgetDeclaredMenu()
+	t.resetMenuItems()
+}
+
+func (t *Tray) resetMenuItems() {
 	menuItemsLock.Lock()
 	id := currentID.Load()
 	items := make([]*MenuItem, 0, len(menuItems))
@@ -147,31 +423,55 @@ func ResetMenu() {
 	}
 	menuItemsLock.Unlock()
 	for _, item := range items {
-		if item.id <= id && item.parent == nil {
+		if item.id <= id && item.parent == nil && item.tray == t {
 			item.Remove()
 		}
 	}
-	resetMenu()
+	menuItemsLock.Lock()
+	t.menuOrder = make(map[uint32][]uint32)
+	menuItemsLock.Unlock()
+	currentBackend().resetMenu(t)
 }
 
-// Quit the systray
+// Quit the systray. Run may be called again afterwards, the menus then start empty.
 func Quit() {
-	quitOnce.Do(quit)
+	if !quitCalled.CompareAndSwap(false, true) {
+		return
+	}
+	currentBackend().quit()
 }
 
-func SetOnTapped(f func()) {
-	tappedLeft = f
+// HostAvailable returns whether the icon of the tray can currently be displayed. On Linux, it
+// is false until the icon is registered with a StatusNotifierWatcher having a host, such as a
+// panel, so that an application can show a window instead when there is no system tray.
+func (t *Tray) HostAvailable() bool {
+	return t.hostAvailable.Load()
 }
 
-func SetOnSecondaryTapped(f func()) {
-	tappedRight = f
+// SetOnHostChanged sets a function to be called each time HostAvailable of the tray changes,
+// with its new value. It is called on the same goroutine as OnClick functions.
+func (t *Tray) SetOnHostChanged(f func(available bool)) {
+	t.hostLock.Lock()
+	t.hostChanged = f
+	t.hostLock.Unlock()
+}
+
+func (t *Tray) setHostAvailable(available bool) {
+	t.hostLock.Lock()
+	defer t.hostLock.Unlock()
+	if t.hostAvailable.Swap(available) == available {
+		return
+	}
+	if f := t.hostChanged; f != nil {
+		callbacks.push(func() { f(available) })
+	}
 }
 
 // AddMenuItem adds a menu item with the designated title and tooltip.
 // It can be safely invoked from different goroutines.
 // Created menu items are checkable on Windows and OSX by default. For Linux you have to use AddMenuItemCheckbox
-func AddMenuItem(title string, tooltip string) *MenuItem {
-	item := newMenuItem(title, tooltip, nil)
+func (t *Tray) AddMenuItem(title string, tooltip string) *MenuItem {
+	item := newMenuItem(t, title, tooltip, nil)
 	item.update()
 	return item
 }
@@ -179,29 +479,53 @@ func AddMenuItem(title string, tooltip string) *MenuItem {
 // AddMenuItemCheckbox adds a menu item with the designated title and tooltip and a checkbox for Linux.
 // On other platforms there will be a check indicated next to the item if `checked` is true.
 // It can be safely invoked from different goroutines.
-func AddMenuItemCheckbox(title string, tooltip string, checked bool) *MenuItem {
-	item := newMenuItem(title, tooltip, nil)
+func (t *Tray) AddMenuItemCheckbox(title string, tooltip string, checked bool) *MenuItem {
+	item := newMenuItem(t, title, tooltip, nil)
 	item.isCheckable = true
 	item.checked = checked
 	item.update()
 	return item
 }
 
+// AddMenuItemRadio adds a menu item with the designated title and tooltip to the group of
+// mutually exclusive items named group, groups being shared by the whole menu of the tray.
+// Clicking an item of the group checks it and unchecks the others before notifying its ClickedCh.
+// It can be safely invoked from different goroutines.
+func (t *Tray) AddMenuItemRadio(group string, title string, tooltip string) *MenuItem {
+	item := newMenuItem(t, title, tooltip, nil)
+	item.isCheckable = true
+	item.radioGroup = group
+	item.update()
+	return item
+}
+
+// AddMenuItemAt adds a menu item with the designated title and tooltip at index among the
+// top level items and separators, including hidden ones. An index out of range appends the item.
+// It can be safely invoked from different goroutines.
+func (t *Tray) AddMenuItemAt(index int, title string, tooltip string) *MenuItem {
+	item := newMenuItem(t, title, tooltip, nil)
+	item.setIndex(index)
+	item.update()
+	return item
+}
+
 // AddSeparator adds a separator bar to the menu
-func AddSeparator() {
-	addSeparator(currentID.Add(1), 0)
+func (t *Tray) AddSeparator() {
+	id := t.newSeparatorID(0, -1)
+	currentBackend().addSeparator(t, id, 0)
 }
 
 // AddSeparator adds a separator bar to the submenu
 func (item *MenuItem) AddSeparator() {
-	addSeparator(currentID.Add(1), item.id)
+	id := item.tray.newSeparatorID(item.id, -1)
+	currentBackend().addSeparator(item.tray, id, item.id)
 }
 
 // AddSubMenuItem adds a nested sub-menu item with the designated title and tooltip.
 // It can be safely invoked from different goroutines.
 // Created menu items are checkable on Windows and OSX by default. For Linux you have to use AddSubMenuItemCheckbox
 func (item *MenuItem) AddSubMenuItem(title string, tooltip string) *MenuItem {
-	child := newMenuItem(title, tooltip, item)
+	child := newMenuItem(item.tray, title, tooltip, item)
 	child.update()
 	return child
 }
@@ -210,13 +534,69 @@ func (item *MenuItem) AddSubMenuItem(title string, tooltip string) *MenuItem {
 // It can be safely invoked from different goroutines.
 // On Windows and OSX this is the same as calling AddSubMenuItem
 func (item *MenuItem) AddSubMenuItemCheckbox(title string, tooltip string, checked bool) *MenuItem {
-	child := newMenuItem(title, tooltip, item)
+	child := newMenuItem(item.tray, title, tooltip, item)
 	child.isCheckable = true
 	child.checked = checked
 	child.update()
 	return child
 }
 
+// AddSubMenuItemRadio adds a nested sub-menu item with the designated title and tooltip to the
+// group of mutually exclusive items named group, groups being shared by the whole menu of the tray.
+// It can be safely invoked from different goroutines.
+func (item *MenuItem) AddSubMenuItemRadio(group string, title string, tooltip string) *MenuItem {
+	child := newMenuItem(item.tray, title, tooltip, item)
+	child.isCheckable = true
+	child.radioGroup = group
+	child.update()
+	return child
+}
+
+// AddSubMenuItemAt adds a nested sub-menu item with the designated title and tooltip at index
+// among the items and separators of the sub-menu, including hidden ones. An index out of range
+// appends the item.
+// It can be safely invoked from different goroutines.
+func (item *MenuItem) AddSubMenuItemAt(index int, title string, tooltip string) *MenuItem {
+	child := newMenuItem(item.tray, title, tooltip, item)
+	child.setIndex(index)
+	child.update()
+	return child
+}
+
+// MoveTo moves the menu item to index among the items and separators of its menu, including
+// hidden ones. An index out of range moves the item to the end.
+func (item *MenuItem) MoveTo(index int) {
+	if !item.setIndex(index) {
+		return
+	}
+	currentBackend().moveMenuItem(item)
+}
+
+// InsertBefore moves the menu item just before other, both items must be in the same menu.
+func (item *MenuItem) InsertBefore(other *MenuItem) {
+	if item.parent != other.parent {
+		logError("cannot insert a menu item before an item of another menu", "item", item.id, "other", other.id)
+		return
+	}
+	index := item.tray.menuIndex(other.parentId(), other.id)
+	if current := item.tray.menuIndex(item.parentId(), item.id); current >= 0 && current < index {
+		index-- // other shifts up once item is taken out
+	}
+	item.MoveTo(index)
+}
+
+// setIndex moves the item to index in the menu order, it returns false if the item was removed
+func (item *MenuItem) setIndex(index int) bool {
+	menuItemsLock.Lock()
+	defer menuItemsLock.Unlock()
+	if _, exists := menuItems[item.id]; !exists {
+		return false
+	}
+	item.tray.removeMenuID(item.parentId(), item.id)
+	item.tray.insertMenuID(item.parentId(), item.id, index)
+	return true
+}
+
 // SetTitle set the text to display on a menu item
 func (item *MenuItem) SetTitle(title string) {
 	item.title = title
@@ -229,6 +609,39 @@ func (item *MenuItem) SetTooltip(tooltip string) {
 	item.update()
 }
 
+// SetShortcut sets the keyboard shortcut displayed next to the menu item, for example
+// SetShortcut(ModifierControl|ModifierShift, "Q"). Key is a character or a key name such as
+// "F5", "Delete" or "Escape", an empty key removes the shortcut.
+// The shortcut is a hint, the application remains responsible for handling it outside the menu.
+func (item *MenuItem) SetShortcut(modifiers Modifier, key string) {
+	item.shortcutModifiers = modifiers
+	item.shortcutKey = key
+	item.update()
+}
+
+// Events returns a channel receiving every event on the menu item along with its timestamp and
+// keyboard modifiers, as an alternative to ClickedCh, HoveredCh, OpenedCh and ClosedCh.
+// The channel is buffered, events are dropped while it is full. It is closed when the item is removed.
+func (item *MenuItem) Events() <-chan MenuEvent {
+	menuItemsLock.Lock()
+	defer menuItemsLock.Unlock()
+	if item.events == nil {
+		item.events = make(chan MenuEvent, 16)
+		if _, exists := menuItems[item.id]; !exists {
+			close(item.events)
+		}
+	}
+	return item.events
+}
+
+// SetOnAboutToShow sets a function to be called just before the sub-menu of the item is shown,
+// so that its items can be added or updated on demand. The item is displayed as a sub-menu even
+// while it has no items yet. The function is called from the event loop and should return quickly.
+func (item *MenuItem) SetOnAboutToShow(f func()) {
+	item.aboutToShow = f
+	item.update()
+}
+
 // Disabled checks if the menu item is disabled
 func (item *MenuItem) Disabled() bool {
 	return item.disabled
@@ -248,36 +661,59 @@ func (item *MenuItem) Disable() {
 
 // Hide hides a menu item
 func (item *MenuItem) Hide() {
-	hideMenuItem(item)
+	item.hidden = true
+	currentBackend().hideMenuItem(item)
 }
 
-// Remove removes a menu item
+// Remove removes a menu item. It does nothing once the item has been removed, along with
+// its parent or the menu by ResetMenu, Quit or Tray.Remove.
 func (item *MenuItem) Remove() {
-	menuItemsLock.RLock()
+	menuItemsLock.Lock()
+	select {
+	case <-item.removed:
+		menuItemsLock.Unlock()
+		return
+	default:
+	}
+	// stop queued clicks before closing ClickedCh
+	close(item.removed)
+	if item.clicks != nil {
+		item.clicks.stop()
+	}
 	var childList []*MenuItem
 	for _, child := range menuItems {
 		if child.parent == item {
 			childList = append(childList, child)
 		}
 	}
-	menuItemsLock.RUnlock()
+	menuItemsLock.Unlock()
 	for _, child := range childList {
 		child.Remove()
 	}
-	removeMenuItem(item)
+	currentBackend().removeMenuItem(item)
+	item.sendLock.Lock()
+	defer item.sendLock.Unlock()
 	menuItemsLock.Lock()
 	delete(menuItems, item.id)
-	select {
-	case <-item.ClickedCh:
-	default:
+	item.tray.removeMenuID(item.parentId(), item.id)
+	delete(item.tray.menuOrder, item.id)
+	for _, ch := range []chan struct{}{item.ClickedCh, item.OpenedCh, item.ClosedCh, item.HoveredCh} {
+		select {
+		case <-ch:
+		default:
+		}
+		close(ch)
+	}
+	if item.events != nil {
+		close(item.events)
 	}
-	close(item.ClickedCh)
 	menuItemsLock.Unlock()
 }
 
 // Show shows a previously hidden menu item
 func (item *MenuItem) Show() {
-	showMenuItem(item)
+	item.hidden = false
+	currentBackend().showMenuItem(item)
 }
 
 // Checked returns if the menu item has a check mark
@@ -285,10 +721,45 @@ func (item *MenuItem) Checked() bool {
 	return item.checked
 }
 
-// Check a menu item regardless if it's previously checked or not
+// Check a menu item regardless if it's previously checked or not.
+// Other items of the same radio group are unchecked.
 func (item *MenuItem) Check() {
+	if item.radioGroup == "" {
+		item.checked = true
+		item.update()
+		return
+	}
+
+	// switch the whole group at once, so that concurrent checks leave a single item checked
+	menuItemsLock.Lock()
+	changed := item.checkInGroup()
+	menuItemsLock.Unlock()
+
+	b := currentBackend()
+	b.beginMenuBatch(item.tray)
+	for _, c := range changed {
+		c.update()
+	}
+	b.endMenuBatch(item.tray)
+}
+
+// RadioGroup returns the name of the radio group of the menu item, or "" if it is not a radio item
+func (item *MenuItem) RadioGroup() string {
+	return item.radioGroup
+}
+
+// checkInGroup checks item and unchecks the other checked items of its radio group, it
+// returns the items changed. menuItemsLock must be held.
+func (item *MenuItem) checkInGroup() []*MenuItem {
+	changed := []*MenuItem{item}
 	item.checked = true
-	item.update()
+	for _, other := range menuItems {
+		if other != item && other.tray == item.tray && other.radioGroup == item.radioGroup && other.checked {
+			other.checked = false
+			changed = append(changed, other)
+		}
+	}
+	return changed
 }
 
 // Uncheck a menu item regardless if it's previously unchecked or not
@@ -299,27 +770,91 @@ func (item *MenuItem) Uncheck() {
 
 // update propagates changes on a menu item to systray
 func (item *MenuItem) update() {
-	menuItemsLock.Lock()
+	if !item.exists() {
+		return
+	}
+	currentBackend().addOrUpdateMenuItem(item)
+}
+
+// exists returns false once the item has been removed
+func (item *MenuItem) exists() bool {
+	menuItemsLock.RLock()
+	defer menuItemsLock.RUnlock()
 	_, exists := menuItems[item.id]
-	menuItemsLock.Unlock()
+	return exists
+}
 
-	if !exists {
-		return
+// systrayMenuAboutToShow calls the function set with SetOnAboutToShow on the item with the ID,
+// it returns false if there is none
+func systrayMenuAboutToShow(id uint32) bool {
+	menuItemsLock.RLock()
+	item, ok := menuItems[id]
+	menuItemsLock.RUnlock()
+	if !ok || item.aboutToShow == nil {
+		return false
 	}
-	addOrUpdateMenuItem(item)
+	item.aboutToShow()
+	return true
 }
 
-func systrayMenuItemSelected(id uint32) {
+// systrayMenuEvent dispatches an event on the item with the ID, or on the top level menu of t for 0
+func systrayMenuEvent(t *Tray, id uint32, event MenuEvent) {
+	if id == 0 {
+		switch event.Kind {
+		case MenuEventOpened:
+			notify(t.OpenedCh)
+		case MenuEventClosed:
+			notify(t.ClosedCh)
+		}
+		return
+	}
+
 	menuItemsLock.RLock()
 	item, ok := menuItems[id]
 	menuItemsLock.RUnlock()
 	if !ok {
-		log.Printf("systray error: no menu item with ID %d\n", id)
+		// hovered separators are reported by some platforms
+		if event.Kind == MenuEventClicked {
+			logError("no such menu item", "item", id)
+		}
 		return
 	}
+	if event.Kind == MenuEventClicked && item.radioGroup != "" && !item.checked {
+		item.Check()
+	}
+
+	menuItemsLock.RLock()
+	if _, ok := menuItems[id]; !ok {
+		menuItemsLock.RUnlock()
+		return
+	}
+	switch event.Kind {
+	case MenuEventClicked:
+		item.deliverClick()
+	case MenuEventHovered:
+		notify(item.HoveredCh)
+	case MenuEventOpened:
+		notify(item.OpenedCh)
+	case MenuEventClosed:
+		notify(item.ClosedCh)
+	}
+	if item.events != nil {
+		select {
+		case item.events <- event:
+		default:
+		}
+	}
+	menuItemsLock.RUnlock()
+}
+
+// notify sends to ch unless no one is waiting for it
+func notify(ch chan struct{}) {
 	select {
-	case item.ClickedCh <- struct{}{}:
-	// in case no one waiting for the channel
+	case ch <- struct{}{}:
 	default:
 	}
 }
+
+func systrayMenuItemSelected(id uint32) {
+	systrayMenuEvent(defaultTray, id, MenuEvent{Kind: MenuEventClicked})
+}
@@ -2,25 +2,39 @@
 
 extern void systray_ready();
 extern void systray_on_exit();
-extern void systray_left_click();
-extern void systray_right_click();
-extern void systray_menu_item_selected(int menu_id);
-extern void systray_menu_will_open();
+extern void systray_left_click(int tray_id, int x, int y);
+extern void systray_right_click(int tray_id, int x, int y);
+extern void systray_middle_click(int tray_id, int x, int y);
+extern void systray_scroll(int tray_id, int delta, bool horizontal);
+extern void systray_menu_item_selected(int tray_id, int menu_id, unsigned int timestamp, int modifiers);
+extern void systray_menu_opened(int tray_id, int menu_id, unsigned int timestamp, int modifiers);
+extern void systray_menu_closed(int tray_id, int menu_id, unsigned int timestamp, int modifiers);
+extern void systray_menu_item_hovered(int tray_id, int menu_id, unsigned int timestamp, int modifiers);
+extern void systray_menu_about_to_show(int tray_id, int menu_id);
 void registerSystray(void);
 void nativeEnd(void);
 int nativeLoop(void);
 void nativeStart(void);
+void create_tray(int trayId);
+void remove_tray(int trayId);
+void set_tray_visible(int trayId, bool visible);
 
-void setIcon(const char* iconBytes, int length, bool template);
-void setMenuItemIcon(const char* iconBytes, int length, int menuId, bool template);
-void setTitle(char* title);
-void setTooltip(char* tooltip);
-void setRemovalAllowed(bool allowed);
-void add_or_update_menu_item(int menuId, int parentMenuId, char* title, char* tooltip, short disabled, short checked, short isCheckable);
-void add_separator(int menuId, int parentId);
-void hide_menu_item(int menuId);
-void remove_menu_item(int menuId);
-void show_menu_item(int menuId);
-void reset_menu();
-void show_menu();
+bool setIcon(int trayId, const char* iconBytes, int length, bool template);
+bool setAttentionIcon(int trayId, const char* iconBytes, int length, bool template);
+bool setOverlayIcon(int trayId, const char* iconBytes, int length);
+bool setMenuItemIcon(int trayId, const char* iconBytes, int length, int menuId, bool template);
+void clearMenuItemIcon(int trayId, int menuId);
+void setTitle(int trayId, char* title);
+void setTooltip(int trayId, char* tooltip);
+void setRemovalAllowed(int trayId, bool allowed);
+void setStatus(int trayId, int status);
+void add_or_update_menu_item(int trayId, int menuId, int parentMenuId, char* title, char* tooltip, short disabled, short checked, short isCheckable, char* keyEquivalent, int modifiers, int index);
+void add_separator(int trayId, int menuId, int parentId, int index);
+void ensure_submenu(int trayId, int menuId);
+void hide_menu_item(int trayId, int menuId);
+void move_menu_item(int trayId, int menuId, int index);
+void remove_menu_item(int trayId, int menuId);
+void show_menu_item(int trayId, int menuId);
+void reset_menu(int trayId);
+void show_menu(int trayId);
 void quit();
@@ -0,0 +1,94 @@
+package systray
+
+import "sync"
+
+// backend shows the trays and their menus. Every change made by the application goes through
+// the current backend: the native one of the platform, unless the systraytest package
+// replaces it by the recording one.
+type backend interface {
+	setIcon(t *Tray, iconBytes []byte) error
+	setTemplateIcon(t *Tray, templateIconBytes, regularIconBytes []byte) error
+	setAttentionIcon(t *Tray, iconBytes []byte) error
+	// setOverlayIcon removes the overlay icon for empty iconBytes
+	setOverlayIcon(t *Tray, iconBytes []byte) error
+	setIconName(t *Tray, name string) error
+	setAttentionIconName(t *Tray, name string) error
+	setOverlayIconName(t *Tray, name string) error
+	setIconThemePath(t *Tray, dir string) error
+	setTitle(t *Tray, title string) error
+	setTooltip(t *Tray, tooltip string) error
+	setStatus(t *Tray, status Status) error
+
+	addOrUpdateMenuItem(item *MenuItem)
+	setMenuItemIcon(item *MenuItem, iconBytes []byte) error
+	setMenuItemTemplateIcon(item *MenuItem, templateIconBytes, regularIconBytes []byte) error
+	setMenuItemIconName(item *MenuItem, name string)
+	clearMenuItemIcon(item *MenuItem)
+	hideMenuItem(item *MenuItem)
+	showMenuItem(item *MenuItem)
+	moveMenuItem(item *MenuItem)
+	removeMenuItem(item *MenuItem)
+	addSeparator(t *Tray, id, parent uint32)
+	removeSeparator(t *Tray, id, parent uint32)
+	resetMenu(t *Tray)
+	// beginMenuBatch defers the signals of the changes made to the menu of the tray until
+	// endMenuBatch, which emits them at once. The property changes of the items passed to
+	// batchMenuItem are not signalled as layout changes. Batches may be nested.
+	beginMenuBatch(t *Tray)
+	batchMenuItem(item *MenuItem)
+	endMenuBatch(t *Tray)
+
+	quit()
+}
+
+var (
+	activeBackend     backend = nativeBackend{}
+	activeBackendLock sync.RWMutex
+)
+
+// currentBackend returns the backend the changes should be passed to
+func currentBackend() backend {
+	activeBackendLock.RLock()
+	defer activeBackendLock.RUnlock()
+	return activeBackend
+}
+
+// nativeBackend is the backend of the platform, the tray setters are implemented along with
+// the rest of the platform code
+type nativeBackend struct{}
+
+func (nativeBackend) addOrUpdateMenuItem(item *MenuItem) {
+	addOrUpdateMenuItem(item)
+}
+
+func (nativeBackend) hideMenuItem(item *MenuItem) {
+	hideMenuItem(item)
+}
+
+func (nativeBackend) showMenuItem(item *MenuItem) {
+	showMenuItem(item)
+}
+
+func (nativeBackend) moveMenuItem(item *MenuItem) {
+	moveMenuItem(item)
+}
+
+func (nativeBackend) removeMenuItem(item *MenuItem) {
+	removeMenuItem(item)
+}
+
+func (nativeBackend) addSeparator(t *Tray, id, parent uint32) {
+	t.addSeparator(id, parent)
+}
+
+func (nativeBackend) removeSeparator(t *Tray, id, parent uint32) {
+	t.removeSeparator(id, parent)
+}
+
+func (nativeBackend) resetMenu(t *Tray) {
+	t.resetMenu()
+}
+
+func (nativeBackend) quit() {
+	quit()
+}
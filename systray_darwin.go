@@ -16,23 +16,90 @@ import "C"
 import (
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"unsafe"
 )
 
-// SetTemplateIcon sets the systray icon as a template icon (on Mac), falling back
+// nativeTray tracks the status item of a Tray, kept by the native code under the tray ID
+type nativeTray struct {
+	// ready is set once the status item exists, changes made before are lost but for status
+	ready atomic.Bool
+	// status is the Status set by SetStatus, applied when the status item is created
+	status atomic.Int32
+}
+
+func newNativeTray(*Tray) *nativeTray {
+	return &nativeTray{}
+}
+
+// trayOf returns the tray with the ID passed by the native code, nil once it is removed
+func trayOf(cID C.int) *Tray {
+	traysLock.Lock()
+	defer traysLock.Unlock()
+	return trays[uint32(cID)]
+}
+
+// SetTemplateIcon sets the tray icon as a template icon (on Mac), falling back
 // to a regular icon on other platforms.
 // templateIconBytes and regularIconBytes should be the content of .ico for windows and
 // .ico/.jpg/.png for other platforms.
-func SetTemplateIcon(templateIconBytes []byte, regularIconBytes []byte) {
-	cstr := (*C.char)(unsafe.Pointer(&templateIconBytes[0]))
-	C.setIcon(cstr, (C.int)(len(templateIconBytes)), true)
+func (t *Tray) SetTemplateIcon(templateIconBytes []byte, regularIconBytes []byte) {
+	logFailure("failed to set template icon", t.TrySetTemplateIcon(templateIconBytes, regularIconBytes), "tray", t.id)
+}
+
+// TrySetTemplateIcon is like SetTemplateIcon but returns an error on failure.
+func (t *Tray) TrySetTemplateIcon(templateIconBytes []byte, regularIconBytes []byte) error {
+	return currentBackend().setTemplateIcon(t, templateIconBytes, regularIconBytes)
+}
+
+func (nativeBackend) setTemplateIcon(t *Tray, templateIconBytes []byte, regularIconBytes []byte) error {
+	return t.setIconData(templateIconBytes, func(cstr *C.char, length C.int) C.bool {
+		return C.setIcon(C.int(t.id), cstr, length, true)
+	})
 }
 
 // SetIcon sets the icon of a menu item. Only works on macOS and Windows.
 // iconBytes should be the content of .ico/.jpg/.png
 func (item *MenuItem) SetIcon(iconBytes []byte) {
+	logFailure("failed to set menu item icon", item.TrySetIcon(iconBytes), "item", item.id)
+}
+
+// TrySetIcon is like SetIcon but returns an error on failure.
+func (item *MenuItem) TrySetIcon(iconBytes []byte) error {
+	return currentBackend().setMenuItemIcon(item, iconBytes)
+}
+
+func (nativeBackend) setMenuItemIcon(item *MenuItem, iconBytes []byte) error {
+	return item.tray.setIconData(iconBytes, func(cstr *C.char, length C.int) C.bool {
+		return C.setMenuItemIcon(C.int(item.tray.id), cstr, length, C.int(item.id), false)
+	})
+}
+
+func (nativeBackend) clearMenuItemIcon(item *MenuItem) {
+	C.clearMenuItemIcon(C.int(item.tray.id), C.int(item.id))
+}
+
+// setIconData passes iconBytes to the native function setting an icon, it returns an error
+// wrapping ErrInvalidIcon if they cannot be decoded
+func (t *Tray) setIconData(iconBytes []byte, set func(*C.char, C.int) C.bool) error {
+	if len(iconBytes) == 0 {
+		return fmt.Errorf("%w: no data", ErrInvalidIcon)
+	}
 	cstr := (*C.char)(unsafe.Pointer(&iconBytes[0]))
-	C.setMenuItemIcon(cstr, (C.int)(len(iconBytes)), C.int(item.id), false)
+	if !set(cstr, (C.int)(len(iconBytes))) {
+		return ErrInvalidIcon
+	}
+	return t.readyError()
+}
+
+// readyError returns ErrNotReady if the change was made before the status item exists
+func (t *Tray) readyError() error {
+	if !t.native.ready.Load() {
+		return ErrNotReady
+	}
+	return nil
 }
 
 // SetIconFromFilePath sets the icon of a menu item from a file path.
@@ -51,26 +118,48 @@ func (item *MenuItem) SetIconFromFilePath(iconFilePath string) error {
 // templateIconBytes and regularIconBytes should be the content of .ico for windows and
 // .ico/.jpg/.png for other platforms.
 func (item *MenuItem) SetTemplateIcon(templateIconBytes []byte, regularIconBytes []byte) {
-	cstr := (*C.char)(unsafe.Pointer(&templateIconBytes[0]))
-	C.setMenuItemIcon(cstr, (C.int)(len(templateIconBytes)), C.int(item.id), true)
+	logFailure("failed to set menu item template icon", item.TrySetTemplateIcon(templateIconBytes, regularIconBytes), "item", item.id)
+}
+
+// TrySetTemplateIcon is like SetTemplateIcon but returns an error on failure.
+func (item *MenuItem) TrySetTemplateIcon(templateIconBytes []byte, regularIconBytes []byte) error {
+	return currentBackend().setMenuItemTemplateIcon(item, templateIconBytes, regularIconBytes)
+}
+
+func (nativeBackend) setMenuItemTemplateIcon(item *MenuItem, templateIconBytes []byte, regularIconBytes []byte) error {
+	return item.tray.setIconData(templateIconBytes, func(cstr *C.char, length C.int) C.bool {
+		return C.setMenuItemIcon(C.int(item.tray.id), cstr, length, C.int(item.id), true)
+	})
 }
 
-// SetRemovalAllowed sets whether a user can remove the systray icon or not.
+// SetRemovalAllowed sets whether a user can remove the tray icon or not.
 // This is only supported on macOS.
-func SetRemovalAllowed(allowed bool) {
-	C.setRemovalAllowed((C.bool)(allowed))
+func (t *Tray) SetRemovalAllowed(allowed bool) {
+	C.setRemovalAllowed(C.int(t.id), (C.bool)(allowed))
 }
 
-func registerSystray() {
+// nativeOptions holds the options of RunContext specific to macOS, there are none
+type nativeOptions struct{}
+
+func registerSystray(nativeOptions) error {
 	C.registerSystray()
+	return nil
 }
 
 func nativeLoop() {
 	C.nativeLoop()
+	runSystrayExit()
+	stopTrays()
+}
+
+func nativeRun() error {
+	nativeLoop()
+	return nil
 }
 
 func nativeEnd() {
 	C.nativeEnd()
+	stopTrays()
 }
 
 func nativeStart() {
@@ -85,34 +174,229 @@ func setInternalLoop(internal bool) {
 	C.setInternalLoop(C.bool(internal))
 }
 
-// SetIcon sets the systray icon.
+// create adds the status item of a tray created by NewTray, hidden if the tray is
+func (t *Tray) create() error {
+	C.create_tray(C.int(t.id))
+	if t.hidden.Load() {
+		C.set_tray_visible(C.int(t.id), false)
+	}
+	if status := t.native.status.Load(); Status(status) != StatusActive {
+		C.setStatus(C.int(t.id), C.int(status))
+	}
+	t.native.ready.Store(true)
+	t.setHostAvailable(true)
+	return nil
+}
+
+// setVisible shows or hides the status item of the tray, keeping its state
+func (t *Tray) setVisible(visible bool) error {
+	C.set_tray_visible(C.int(t.id), C.bool(visible))
+	return nil
+}
+
+// destroy removes the status item of the tray
+func (t *Tray) destroy() error {
+	t.native.ready.Store(false)
+	C.remove_tray(C.int(t.id))
+	return nil
+}
+
+// SetIcon sets the tray icon.
 // iconBytes should be the content of .ico for windows and .ico/.jpg/.png
 // for other platforms.
-func SetIcon(iconBytes []byte) {
-	cstr := (*C.char)(unsafe.Pointer(&iconBytes[0]))
-	C.setIcon(cstr, (C.int)(len(iconBytes)), false)
+func (t *Tray) SetIcon(iconBytes []byte) {
+	logFailure("failed to set icon", t.TrySetIcon(iconBytes), "tray", t.id)
+}
+
+// TrySetIcon is like SetIcon but returns an error on failure.
+func (t *Tray) TrySetIcon(iconBytes []byte) error {
+	return currentBackend().setIcon(t, iconBytes)
+}
+
+func (nativeBackend) setIcon(t *Tray, iconBytes []byte) error {
+	return t.setIconData(iconBytes, func(cstr *C.char, length C.int) C.bool {
+		return C.setIcon(C.int(t.id), cstr, length, false)
+	})
 }
 
-// SetIconFromFilePath sets the systray icon from a file path.
+// SetAttentionIcon sets the icon shown instead of the regular one while the status is
+// StatusNeedsAttention.
+// iconBytes should be the content of .ico for windows and .ico/.jpg/.png
+// for other platforms.
+func (t *Tray) SetAttentionIcon(iconBytes []byte) {
+	logFailure("failed to set attention icon", t.TrySetAttentionIcon(iconBytes), "tray", t.id)
+}
+
+// TrySetAttentionIcon is like SetAttentionIcon but returns an error on failure.
+func (t *Tray) TrySetAttentionIcon(iconBytes []byte) error {
+	return currentBackend().setAttentionIcon(t, iconBytes)
+}
+
+func (nativeBackend) setAttentionIcon(t *Tray, iconBytes []byte) error {
+	return t.setIconData(iconBytes, func(cstr *C.char, length C.int) C.bool {
+		return C.setAttentionIcon(C.int(t.id), cstr, length, false)
+	})
+}
+
+// SetOverlayIcon sets a small icon, such as a badge, drawn over the bottom right
+// corner of the tray icon.
+// iconBytes should be the content of .ico for windows and .ico/.jpg/.png
+// for other platforms.
+func (t *Tray) SetOverlayIcon(iconBytes []byte) {
+	logFailure("failed to set overlay icon", t.TrySetOverlayIcon(iconBytes), "tray", t.id)
+}
+
+// TrySetOverlayIcon is like SetOverlayIcon but returns an error on failure.
+// Empty iconBytes remove the overlay icon.
+func (t *Tray) TrySetOverlayIcon(iconBytes []byte) error {
+	return currentBackend().setOverlayIcon(t, iconBytes)
+}
+
+func (nativeBackend) setOverlayIcon(t *Tray, iconBytes []byte) error {
+	if len(iconBytes) == 0 {
+		C.setOverlayIcon(C.int(t.id), nil, 0)
+		return t.readyError()
+	}
+	return t.setIconData(iconBytes, func(cstr *C.char, length C.int) C.bool {
+		return C.setOverlayIcon(C.int(t.id), cstr, length)
+	})
+}
+
+// ClearOverlayIcon removes the icon set by SetOverlayIcon.
+func (t *Tray) ClearOverlayIcon() {
+	logFailure("failed to clear overlay icon", t.TrySetOverlayIcon(nil), "tray", t.id)
+}
+
+// SetIconFromFilePath sets the tray icon from a file path.
 // iconFilePath should be the path to a .ico for windows and .ico/.jpg/.png for other platforms.
-func SetIconFromFilePath(iconFilePath string) error {
+func (t *Tray) SetIconFromFilePath(iconFilePath string) error {
 	bytes, err := os.ReadFile(iconFilePath)
 	if err != nil {
 		return fmt.Errorf("failed to read icon file: %v", err)
 	}
-	SetIcon(bytes)
+	t.SetIcon(bytes)
+	return nil
+}
+
+// SetIconName sets the tray icon by name from the desktop icon theme, only available on Linux.
+func (t *Tray) SetIconName(name string) {
+	logFailure("failed to set icon name", t.TrySetIconName(name), "tray", t.id)
+}
+
+// TrySetIconName is like SetIconName but returns an error on failure.
+func (t *Tray) TrySetIconName(name string) error {
+	return currentBackend().setIconName(t, name)
+}
+
+func (nativeBackend) setIconName(*Tray, string) error {
+	// do nothing
+	return nil
+}
+
+// SetAttentionIconName sets the icon shown while the status is StatusNeedsAttention by name
+// from the desktop icon theme, only available on Linux.
+func (t *Tray) SetAttentionIconName(name string) {
+	logFailure("failed to set attention icon name", t.TrySetAttentionIconName(name), "tray", t.id)
+}
+
+// TrySetAttentionIconName is like SetAttentionIconName but returns an error on failure.
+func (t *Tray) TrySetAttentionIconName(name string) error {
+	return currentBackend().setAttentionIconName(t, name)
+}
+
+func (nativeBackend) setAttentionIconName(*Tray, string) error {
+	// do nothing
+	return nil
+}
+
+// SetOverlayIconName sets the icon drawn over the tray icon by name from the desktop icon theme,
+// only available on Linux.
+func (t *Tray) SetOverlayIconName(name string) {
+	logFailure("failed to set overlay icon name", t.TrySetOverlayIconName(name), "tray", t.id)
+}
+
+// TrySetOverlayIconName is like SetOverlayIconName but returns an error on failure.
+func (t *Tray) TrySetOverlayIconName(name string) error {
+	return currentBackend().setOverlayIconName(t, name)
+}
+
+func (nativeBackend) setOverlayIconName(*Tray, string) error {
+	// do nothing
+	return nil
+}
+
+// SetIconThemePath adds a directory to the icon theme search path, only available on Linux.
+func (t *Tray) SetIconThemePath(dir string) {
+	logFailure("failed to set icon theme path", t.TrySetIconThemePath(dir), "tray", t.id)
+}
+
+// TrySetIconThemePath is like SetIconThemePath but returns an error on failure.
+func (t *Tray) TrySetIconThemePath(dir string) error {
+	return currentBackend().setIconThemePath(t, dir)
+}
+
+func (nativeBackend) setIconThemePath(*Tray, string) error {
+	// do nothing
 	return nil
 }
 
-// SetTitle sets the systray title, only available on Mac and Linux.
-func SetTitle(title string) {
-	C.setTitle(C.CString(title))
+// SetIconName sets the icon of a menu item by name from the desktop icon theme,
+// only available on Linux.
+func (item *MenuItem) SetIconName(name string) {
+	currentBackend().setMenuItemIconName(item, name)
+}
+
+func (nativeBackend) setMenuItemIconName(*MenuItem, string) {
+	// do nothing
 }
 
-// SetTooltip sets the systray tooltip to display on mouse hover of the tray icon,
+// SetTitle sets the tray title, only available on Mac and Linux.
+func (t *Tray) SetTitle(title string) {
+	logFailure("failed to set title", t.TrySetTitle(title), "tray", t.id)
+}
+
+// TrySetTitle is like SetTitle but returns an error on failure.
+func (t *Tray) TrySetTitle(title string) error {
+	return currentBackend().setTitle(t, title)
+}
+
+func (nativeBackend) setTitle(t *Tray, title string) error {
+	C.setTitle(C.int(t.id), C.CString(title))
+	return t.readyError()
+}
+
+// SetTooltip sets the tray tooltip to display on mouse hover of the tray icon,
 // only available on Mac and Windows.
-func SetTooltip(tooltip string) {
-	C.setTooltip(C.CString(tooltip))
+func (t *Tray) SetTooltip(tooltip string) {
+	logFailure("failed to set tooltip", t.TrySetTooltip(tooltip), "tray", t.id)
+}
+
+// TrySetTooltip is like SetTooltip but returns an error on failure.
+func (t *Tray) TrySetTooltip(tooltip string) error {
+	return currentBackend().setTooltip(t, tooltip)
+}
+
+func (nativeBackend) setTooltip(t *Tray, tooltip string) error {
+	C.setTooltip(C.int(t.id), C.CString(tooltip))
+	return t.readyError()
+}
+
+// SetStatus sets the status of the tray icon.
+// On macOS a passive icon is hidden from the menu bar.
+func (t *Tray) SetStatus(status Status) {
+	logFailure("failed to set status", t.TrySetStatus(status), "tray", t.id)
+}
+
+// TrySetStatus is like SetStatus but returns an error on failure.
+func (t *Tray) TrySetStatus(status Status) error {
+	return currentBackend().setStatus(t, status)
+}
+
+// setStatus keeps the status until the status item is created if need be
+func (nativeBackend) setStatus(t *Tray, status Status) error {
+	t.native.status.Store(int32(status))
+	C.setStatus(C.int(t.id), C.int(status))
+	return nil
 }
 
 func addOrUpdateMenuItem(item *MenuItem) {
@@ -133,6 +417,7 @@ func addOrUpdateMenuItem(item *MenuItem) {
 		parentID = item.parent.id
 	}
 	C.add_or_update_menu_item(
+		C.int(item.tray.id),
 		C.int(item.id),
 		C.int(parentID),
 		C.CString(item.title),
@@ -140,57 +425,146 @@ func addOrUpdateMenuItem(item *MenuItem) {
 		disabled,
 		checked,
 		isCheckable,
+		C.CString(keyEquivalent(item.shortcutKey)),
+		C.int(item.shortcutModifiers),
+		C.int(item.tray.menuIndex(parentID, item.id)),
 	)
+	if item.aboutToShow != nil {
+		C.ensure_submenu(C.int(item.tray.id), C.int(item.id))
+	}
 }
 
-func addSeparator(id uint32, parent uint32) {
-	C.add_separator(C.int(id), C.int(parent))
+// keyEquivalent returns the NSMenuItem key equivalent for a shortcut key.
+func keyEquivalent(key string) string {
+	switch strings.ToLower(key) {
+	case "return", "enter":
+		return "\r"
+	case "escape", "esc":
+		return "\x1b"
+	case "tab":
+		return "\t"
+	case "space":
+		return " "
+	case "backspace":
+		return "\b"
+	case "delete":
+		return string(rune(0xF728)) // NSDeleteFunctionKey
+	}
+	if len(key) > 1 && (key[0] == 'F' || key[0] == 'f') {
+		if n, err := strconv.Atoi(key[1:]); err == nil && n >= 1 && n <= 35 {
+			return string(rune(0xF704 + n - 1)) // NSF1FunctionKey onwards
+		}
+	}
+	return strings.ToLower(key)
+}
+
+func (t *Tray) addSeparator(id uint32, parent uint32) {
+	C.add_separator(C.int(t.id), C.int(id), C.int(parent), C.int(t.menuIndex(parent, id)))
+}
+
+func (t *Tray) removeSeparator(id uint32, parent uint32) {
+	C.remove_menu_item(C.int(t.id), C.int(id))
 }
 
 func hideMenuItem(item *MenuItem) {
 	C.hide_menu_item(
+		C.int(item.tray.id),
 		C.int(item.id),
 	)
 }
 
 func showMenuItem(item *MenuItem) {
 	C.show_menu_item(
+		C.int(item.tray.id),
+		C.int(item.id),
+	)
+}
+
+func moveMenuItem(item *MenuItem) {
+	C.move_menu_item(
+		C.int(item.tray.id),
 		C.int(item.id),
+		C.int(item.tray.menuIndex(item.parentId(), item.id)),
 	)
 }
 
 func removeMenuItem(item *MenuItem) {
 	C.remove_menu_item(
+		C.int(item.tray.id),
 		C.int(item.id),
 	)
 }
 
-func resetMenu() {
-	C.reset_menu()
+func (nativeBackend) beginMenuBatch(*Tray)    {}
+func (nativeBackend) batchMenuItem(*MenuItem) {}
+func (nativeBackend) endMenuBatch(*Tray)      {}
+
+func (t *Tray) resetMenu() {
+	C.reset_menu(C.int(t.id))
 }
 
 //export systray_left_click
-func systray_left_click() {
-	if fn := tappedLeft; fn != nil {
-		fn()
+func systray_left_click(cTrayID, x, y C.int) {
+	t := trayOf(cTrayID)
+	if t == nil {
+		return
+	}
+	if fn := t.tappedLeftHandler(); fn != nil {
+		fn(int(x), int(y))
 		return
 	}
 
-	C.show_menu()
+	C.show_menu(cTrayID)
 }
 
 //export systray_right_click
-func systray_right_click() {
-	if fn := tappedRight; fn != nil {
-		fn()
+func systray_right_click(cTrayID, x, y C.int) {
+	t := trayOf(cTrayID)
+	if t == nil {
+		return
+	}
+	if fn := t.tappedRightHandler(); fn != nil {
+		fn(int(x), int(y))
 		return
 	}
 
-	C.show_menu()
+	C.show_menu(cTrayID)
+}
+
+//export systray_middle_click
+func systray_middle_click(cTrayID, x, y C.int) {
+	t := trayOf(cTrayID)
+	if t == nil {
+		return
+	}
+	if fn := t.tappedMiddleHandler(); fn != nil {
+		fn(int(x), int(y))
+	}
+}
+
+//export systray_scroll
+func systray_scroll(cTrayID C.int, delta C.int, horizontal C.bool) {
+	t := trayOf(cTrayID)
+	if t == nil {
+		return
+	}
+	fn := t.scrollHandler()
+	if fn == nil {
+		return
+	}
+
+	orientation := OrientationVertical
+	if horizontal {
+		orientation = OrientationHorizontal
+	}
+	fn(int(delta), orientation)
 }
 
 //export systray_ready
 func systray_ready() {
+	defaultTray.native.ready.Store(true)
+	defaultTray.setHostAvailable(true)
+	startTrays()
 	systrayReady()
 }
 
@@ -200,14 +574,42 @@ func systray_on_exit() {
 }
 
 //export systray_menu_item_selected
-func systray_menu_item_selected(cID C.int) {
-	systrayMenuItemSelected(uint32(cID))
+func systray_menu_item_selected(cTrayID, cID C.int, timestamp C.uint, modifiers C.int) {
+	t := trayOf(cTrayID)
+	if t == nil {
+		return
+	}
+	systrayMenuEvent(t, uint32(cID), MenuEvent{Kind: MenuEventClicked, Timestamp: uint32(timestamp), Modifiers: Modifier(modifiers)})
+}
+
+//export systray_menu_about_to_show
+func systray_menu_about_to_show(cTrayID, cID C.int) {
+	systrayMenuAboutToShow(uint32(cID))
 }
 
-//export systray_menu_will_open
-func systray_menu_will_open() {
-	select {
-	case TrayOpenedCh <- struct{}{}:
-	default:
+//export systray_menu_opened
+func systray_menu_opened(cTrayID, cID C.int, timestamp C.uint, modifiers C.int) {
+	t := trayOf(cTrayID)
+	if t == nil {
+		return
+	}
+	systrayMenuEvent(t, uint32(cID), MenuEvent{Kind: MenuEventOpened, Timestamp: uint32(timestamp), Modifiers: Modifier(modifiers)})
+}
+
+//export systray_menu_item_hovered
+func systray_menu_item_hovered(cTrayID, cID C.int, timestamp C.uint, modifiers C.int) {
+	t := trayOf(cTrayID)
+	if t == nil {
+		return
+	}
+	systrayMenuEvent(t, uint32(cID), MenuEvent{Kind: MenuEventHovered, Timestamp: uint32(timestamp), Modifiers: Modifier(modifiers)})
+}
+
+//export systray_menu_closed
+func systray_menu_closed(cTrayID, cID C.int, timestamp C.uint, modifiers C.int) {
+	t := trayOf(cTrayID)
+	if t == nil {
+		return
 	}
+	systrayMenuEvent(t, uint32(cID), MenuEvent{Kind: MenuEventClosed, Timestamp: uint32(timestamp), Modifiers: Modifier(modifiers)})
 }
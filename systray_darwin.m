@@ -24,13 +24,19 @@
     NSString* tooltip;
     short disabled;
     short checked;
+    NSString* keyEquivalent;
+    int modifiers;
+    int index;
 }
 -(id) initWithId: (int)theMenuId
 withParentMenuId: (int)theParentMenuId
        withTitle: (const char*)theTitle
      withTooltip: (const char*)theTooltip
     withDisabled: (short)theDisabled
-     withChecked: (short)theChecked;
+     withChecked: (short)theChecked
+withKeyEquivalent: (const char*)theKeyEquivalent
+   withModifiers: (int)theModifiers
+       withIndex: (int)theIndex;
      @end
      @implementation MenuItem
      -(id) initWithId: (int)theMenuId
@@ -39,6 +45,9 @@ withParentMenuId: (int)theParentMenuId
           withTooltip: (const char*)theTooltip
          withDisabled: (short)theDisabled
           withChecked: (short)theChecked
+    withKeyEquivalent: (const char*)theKeyEquivalent
+        withModifiers: (int)theModifiers
+            withIndex: (int)theIndex
 {
   menuId = [NSNumber numberWithInt:theMenuId];
   parentMenuId = [NSNumber numberWithInt:theParentMenuId];
@@ -48,6 +57,10 @@ withParentMenuId: (int)theParentMenuId
                                      encoding:NSUTF8StringEncoding];
   disabled = theDisabled;
   checked = theChecked;
+  keyEquivalent = [[NSString alloc] initWithCString:theKeyEquivalent
+                                           encoding:NSUTF8StringEncoding];
+  modifiers = theModifiers;
+  index = theIndex;
   return self;
 }
 @end
@@ -55,6 +68,8 @@ withParentMenuId: (int)theParentMenuId
 @interface RightClickDetector : NSView
 
 @property (copy) void (^onRightClicked)(NSEvent *);
+@property (copy) void (^onMiddleClicked)(NSEvent *);
+@property (copy) void (^onScrolled)(NSEvent *);
 
 @end
 
@@ -68,27 +83,53 @@ withParentMenuId: (int)theParentMenuId
   self.onRightClicked(theEvent);
 }
 
+- (void)otherMouseUp:(NSEvent *)theEvent {
+  if (!self.onMiddleClicked || theEvent.buttonNumber != 2) {
+    return;
+  }
+
+  self.onMiddleClicked(theEvent);
+}
+
+- (void)scrollWheel:(NSEvent *)theEvent {
+  if (!self.onScrolled) {
+    return;
+  }
+
+  self.onScrolled(theEvent);
+}
+
 @end
 
 
-@interface SystrayAppDelegate: NSObject <NSApplicationDelegate, NSMenuDelegate>
+// SystrayIcon is the status item of a systray.Tray along with its menu
+@interface SystrayIcon: NSObject <NSMenuDelegate>
+  - (id) initWithTrayId:(int)theTrayId;
+  - (void) remove;
   - (void) add_or_update_menu_item:(MenuItem*) item;
   - (IBAction)menuHandler:(id)sender;
   - (void)menuWillOpen:(NSMenu*)menu;
-  @property (assign) IBOutlet NSWindow *window;
+  - (void)menuDidClose:(NSMenu*)menu;
 @end
 
-@implementation SystrayAppDelegate
+@implementation SystrayIcon
 {
+  int trayId;
   NSStatusItem *statusItem;
   NSMenu *menu;
-  NSCondition* cond;
+  id clickMonitor;
+  NSImage *icon;
+  NSImage *attentionIcon;
+  NSImage *overlayIcon;
+  int status;
+  // hidden is set by setVisible, the item is shown unless hidden or passive
+  BOOL hidden;
 }
 
-@synthesize window = _window;
-
-- (void)applicationDidFinishLaunching:(NSNotification *)aNotification
+- (id)initWithTrayId:(int)theTrayId
 {
+  self = [super init];
+  self->trayId = theTrayId;
   self->statusItem = [[NSStatusBar systemStatusBar] statusItemWithLength:NSVariableStatusItemLength];
 
   self->menu = [[NSMenu alloc] init];
@@ -103,7 +144,7 @@ withParentMenuId: (int)theParentMenuId
   NSStatusBarButton *button = self->statusItem.button;
   button.action = @selector(leftMouseClicked);
 
-  [NSEvent addLocalMonitorForEventsMatchingMask: (NSEventTypeLeftMouseDown|NSEventTypeRightMouseDown)
+  self->clickMonitor = [NSEvent addLocalMonitorForEventsMatchingMask: (NSEventTypeLeftMouseDown|NSEventTypeRightMouseDown)
                                         handler: ^NSEvent *(NSEvent *event) {
     if (event.window != self->statusItem.button.window) {
       return event;
@@ -124,26 +165,69 @@ withParentMenuId: (int)theParentMenuId
   rightClicker.onRightClicked = ^(NSEvent *event) {
     [self rightMouseClicked];
   };
+  rightClicker.onMiddleClicked = ^(NSEvent *event) {
+    [self middleMouseClicked];
+  };
+  rightClicker.onScrolled = ^(NSEvent *event) {
+    [self scrolled:event];
+  };
 
   rightClicker.autoresizingMask = (NSViewWidthSizable |
                                    NSViewHeightSizable);
   button.autoresizesSubviews = YES;
   [button addSubview:rightClicker];
+  return self;
+}
 
-  systray_ready();
+- (void)remove
+{
+  [NSEvent removeMonitor:self->clickMonitor];
+  [[NSStatusBar systemStatusBar] removeStatusItem:self->statusItem];
+}
+
+- (void)setVisible:(NSNumber*)visible
+{
+  self->hidden = ![visible boolValue];
+  [self updateVisibility];
+}
+
+- (void)updateVisibility
+{
+  // 1 matches systray.StatusPassive
+  self->statusItem.visible = !self->hidden && self->status != 1;
+}
+
+// clickLocation returns the mouse position with the origin at the top left of the main screen.
+- (NSPoint)clickLocation {
+  NSPoint location = [NSEvent mouseLocation];
+  NSRect screen = [[[NSScreen screens] objectAtIndex:0] frame];
+  return NSMakePoint(location.x, screen.size.height - location.y);
 }
 
 - (void)rightMouseClicked {
-  systray_right_click();
+  NSPoint location = [self clickLocation];
+  systray_right_click(self->trayId, (int)location.x, (int)location.y);
 }
 
 - (void)leftMouseClicked {
-  systray_left_click();
+  NSPoint location = [self clickLocation];
+  systray_left_click(self->trayId, (int)location.x, (int)location.y);
 }
 
-- (void)applicationWillTerminate:(NSNotification *)aNotification
-{
-  systray_on_exit();
+- (void)middleMouseClicked {
+  NSPoint location = [self clickLocation];
+  systray_middle_click(self->trayId, (int)location.x, (int)location.y);
+}
+
+- (void)scrolled:(NSEvent *)event {
+  // line based deltas are scaled to match a wheel notch on other platforms
+  CGFloat scale = event.hasPreciseScrollingDeltas ? 1 : 120;
+  if (event.scrollingDeltaY != 0) {
+    systray_scroll(self->trayId, (int)(event.scrollingDeltaY * scale), false);
+  }
+  if (event.scrollingDeltaX != 0) {
+    systray_scroll(self->trayId, (int)(event.scrollingDeltaX * scale), true);
+  }
 }
 
 - (void)setRemovalAllowed {
@@ -157,11 +241,50 @@ withParentMenuId: (int)theParentMenuId
   behavior &= ~NSStatusItemBehaviorRemovalAllowed;
   // Ensure the menu item is visible if it was removed, since we're now
   // disallowing removal.
-  self->statusItem.visible = TRUE;
+  [self updateVisibility];
   self->statusItem.behavior = behavior;
 }
 
+- (void)setStatus:(NSNumber *)status {
+  self->status = [status intValue];
+  [self updateVisibility];
+  [self updateIcon];
+}
+
 - (void)setIcon:(NSImage *)image {
+  self->icon = image;
+  [self updateIcon];
+}
+
+- (void)setAttentionIcon:(NSImage *)image {
+  self->attentionIcon = image;
+  [self updateIcon];
+}
+
+- (void)setOverlayIcon:(NSImage *)image {
+  self->overlayIcon = image;
+  [self updateIcon];
+}
+
+- (void)updateIcon {
+  NSImage *image = self->icon;
+  // matches systray.StatusNeedsAttention
+  if (self->status == 2 && self->attentionIcon != nil) {
+    image = self->attentionIcon;
+  }
+  if (image != nil && self->overlayIcon != nil) {
+    NSImage *base = image;
+    NSImage *overlay = self->overlayIcon;
+    image = [NSImage imageWithSize:base.size
+                           flipped:NO
+                    drawingHandler:^BOOL(NSRect rect) {
+      [base drawInRect:rect];
+      [overlay drawInRect:NSMakeRect(rect.size.width / 2, 0,
+                                     rect.size.width / 2, rect.size.height / 2)];
+      return YES;
+    }];
+    image.template = base.template;
+  }
   statusItem.button.image = image;
   [self updateTitleButtonStyle];
 }
@@ -188,14 +311,68 @@ withParentMenuId: (int)theParentMenuId
   statusItem.button.toolTip = tooltip;
 }
 
+// event_timestamp returns the time of the event being handled in milliseconds since boot
+unsigned int event_timestamp() {
+  NSEvent* event = [NSApp currentEvent];
+  if (event == nil) {
+    return 0;
+  }
+  return (unsigned int)(event.timestamp * 1000);
+}
+
+// event_modifiers returns the modifiers of the event being handled as a systray.Modifier
+int event_modifiers() {
+  NSEventModifierFlags flags = [NSEvent modifierFlags];
+  int modifiers = 0;
+  if (flags & NSEventModifierFlagControl) {
+    modifiers |= 1;
+  }
+  if (flags & NSEventModifierFlagShift) {
+    modifiers |= 2;
+  }
+  if (flags & NSEventModifierFlagOption) {
+    modifiers |= 4;
+  }
+  if (flags & NSEventModifierFlagCommand) {
+    modifiers |= 8;
+  }
+  return modifiers;
+}
+
 - (IBAction)menuHandler:(id)sender
 {
   NSNumber* menuId = [sender representedObject];
-  systray_menu_item_selected(menuId.intValue);
+  systray_menu_item_selected(self->trayId, menuId.intValue, event_timestamp(), event_modifiers());
+}
+
+// menu_owner_id returns the ID of the item the menu is the submenu of, 0 for the top level menu
+int menu_owner_id(NSMenu *theMenu) {
+  for (NSMenuItem* item in theMenu.supermenu.itemArray) {
+    if (item.submenu == theMenu) {
+      return (int)item.tag;
+    }
+  }
+  return 0;
 }
 
-- (void)menuWillOpen:(NSMenu *)menu {
-  systray_menu_will_open();
+- (void)menuWillOpen:(NSMenu *)theMenu {
+  systray_menu_opened(self->trayId, menu_owner_id(theMenu), event_timestamp(), event_modifiers());
+}
+
+- (void)menuDidClose:(NSMenu *)theMenu {
+  systray_menu_closed(self->trayId, menu_owner_id(theMenu), event_timestamp(), event_modifiers());
+}
+
+- (void)menu:(NSMenu *)theMenu willHighlightItem:(NSMenuItem *)item {
+  if (item != nil && !item.isSeparatorItem) {
+    systray_menu_item_hovered(self->trayId, (int)item.tag, event_timestamp(), event_modifiers());
+  }
+}
+
+- (void)menuNeedsUpdate:(NSMenu *)theMenu {
+  if (theMenu != self->menu) {
+    systray_menu_about_to_show(self->trayId, menu_owner_id(theMenu));
+  }
 }
 
 - (void)add_or_update_menu_item:(MenuItem *)item {
@@ -208,21 +385,43 @@ withParentMenuId: (int)theParentMenuId
     } else {
       theMenu = [[NSMenu alloc] init];
       [theMenu setAutoenablesItems:NO];
+      theMenu.delegate = self;
       [parentItem setSubmenu:theMenu];
     }
   }
 
   NSMenuItem *menuItem = find_menu_item(theMenu, item->menuId);
   if (menuItem == NULL) {
-    menuItem = [theMenu addItemWithTitle:item->title
-                               action:@selector(menuHandler:)
-                        keyEquivalent:@""];
+    NSInteger index = item->index;
+    if (index < 0 || index > theMenu.numberOfItems) {
+      index = theMenu.numberOfItems;
+    }
+    menuItem = [theMenu insertItemWithTitle:item->title
+                                     action:@selector(menuHandler:)
+                              keyEquivalent:@""
+                                    atIndex:index];
     [menuItem setRepresentedObject:item->menuId];
   }
   [menuItem setTitle:item->title];
   [menuItem setTag:[item->menuId integerValue]];
   [menuItem setTarget:self];
   [menuItem setToolTip:item->tooltip];
+  // modifiers match systray.Modifier
+  NSEventModifierFlags modifierMask = 0;
+  if (item->modifiers & 1) {
+    modifierMask |= NSEventModifierFlagControl;
+  }
+  if (item->modifiers & 2) {
+    modifierMask |= NSEventModifierFlagShift;
+  }
+  if (item->modifiers & 4) {
+    modifierMask |= NSEventModifierFlagOption;
+  }
+  if (item->modifiers & 8) {
+    modifierMask |= NSEventModifierFlagCommand;
+  }
+  [menuItem setKeyEquivalent:item->keyEquivalent];
+  [menuItem setKeyEquivalentModifierMask:modifierMask];
   if (item->disabled == 1) {
     menuItem.enabled = FALSE;
   } else {
@@ -255,16 +454,39 @@ NSMenuItem *find_menu_item(NSMenu *ourMenu, NSNumber *menuId) {
   return NULL;
 };
 
-- (void) add_separator:(NSNumber*) parentMenuId
+- (void) add_separator:(NSArray*)ids
 {
+  NSNumber* menuId = [ids objectAtIndex:0];
+  NSNumber* parentMenuId = [ids objectAtIndex:1];
+  NSInteger index = [[ids objectAtIndex:2] integerValue];
+  NSMenu* theMenu = self->menu;
   if (parentMenuId.integerValue != 0) {
     NSMenuItem* menuItem = find_menu_item(menu, parentMenuId);
     if (menuItem != NULL) {
-      [menuItem.submenu addItem: [NSMenuItem separatorItem]];
-      return;
+      theMenu = menuItem.submenu;
     }
   }
-  [menu addItem: [NSMenuItem separatorItem]];
+  NSMenuItem* separator = [NSMenuItem separatorItem];
+  // tagged so that it can be removed like other items
+  [separator setTag:menuId.integerValue];
+  if (index < 0 || index > theMenu.numberOfItems) {
+    index = theMenu.numberOfItems;
+  }
+  [theMenu insertItem:separator atIndex:index];
+}
+
+- (void) ensure_submenu:(NSNumber*) menuId
+{
+  NSMenuItem* menuItem = find_menu_item(menu, menuId);
+  if (menuItem == NULL) {
+    return;
+  }
+  if (!menuItem.hasSubmenu) {
+    NSMenu* submenu = [[NSMenu alloc] init];
+    [submenu setAutoenablesItems:NO];
+    [menuItem setSubmenu:submenu];
+  }
+  menuItem.submenu.delegate = self;
 }
 
 - (void) hide_menu_item:(NSNumber*) menuId
@@ -276,7 +498,10 @@ NSMenuItem *find_menu_item(NSMenu *ourMenu, NSNumber *menuId) {
 }
 
 - (void) setMenuItemIcon:(NSArray*)imageAndMenuId {
-  NSImage* image = [imageAndMenuId objectAtIndex:0];
+  id image = [imageAndMenuId objectAtIndex:0];
+  if (image == [NSNull null]) {
+    image = nil;
+  }
   NSNumber* menuId = [imageAndMenuId objectAtIndex:1];
 
   NSMenuItem* menuItem;
@@ -304,6 +529,22 @@ NSMenuItem *find_menu_item(NSMenu *ourMenu, NSNumber *menuId) {
   }
 }
 
+- (void) move_menu_item:(NSArray*)menuIdAndIndex
+{
+  NSNumber* menuId = [menuIdAndIndex objectAtIndex:0];
+  NSInteger index = [[menuIdAndIndex objectAtIndex:1] integerValue];
+  NSMenuItem* menuItem = find_menu_item(menu, menuId);
+  if (menuItem == NULL) {
+    return;
+  }
+  NSMenu* theMenu = menuItem.menu;
+  [theMenu removeItem:menuItem];
+  if (index < 0 || index > theMenu.numberOfItems) {
+    index = theMenu.numberOfItems;
+  }
+  [theMenu insertItem:menuItem atIndex:index];
+}
+
 - (void) remove_menu_item:(NSNumber*) menuId
 {
   NSMenuItem* menuItem = find_menu_item(menu, menuId);
@@ -317,6 +558,63 @@ NSMenuItem *find_menu_item(NSMenu *ourMenu, NSNumber *menuId) {
   [self->menu removeAllItems];
 }
 
+@end
+
+// tray_icons returns the SystrayIcon of each tray keyed by the tray ID, it is changed on the
+// main thread and read from any thread while synchronized on it
+NSMutableDictionary *tray_icons() {
+  static NSMutableDictionary *icons;
+  static dispatch_once_t once;
+  dispatch_once(&once, ^{
+    icons = [NSMutableDictionary dictionary];
+  });
+  return icons;
+}
+
+// launched is set once the application finished launching, it is not notified again when
+// the event loop is run after Quit
+bool launched = false;
+
+@interface SystrayAppDelegate: NSObject <NSApplicationDelegate>
+  @property (assign) IBOutlet NSWindow *window;
+@end
+
+@implementation SystrayAppDelegate
+
+@synthesize window = _window;
+
+- (void)applicationDidFinishLaunching:(NSNotification *)aNotification
+{
+  launched = true;
+  [self create_tray:[NSNumber numberWithInt:1]];
+  systray_ready();
+}
+
+- (void)applicationWillTerminate:(NSNotification *)aNotification
+{
+  systray_on_exit();
+}
+
+- (void) create_tray:(NSNumber*) trayId
+{
+  SystrayIcon *icon = [[SystrayIcon alloc] initWithTrayId:trayId.intValue];
+  NSMutableDictionary *icons = tray_icons();
+  @synchronized (icons) {
+    [icons setObject:icon forKey:trayId];
+  }
+}
+
+- (void) remove_tray:(NSNumber*) trayId
+{
+  SystrayIcon *icon;
+  NSMutableDictionary *icons = tray_icons();
+  @synchronized (icons) {
+    icon = [icons objectForKey:trayId];
+    [icons removeObjectForKey:trayId];
+  }
+  [icon remove];
+}
+
 - (void) quit
 {
   // This tells the app event loop to stop after processing remaining messages.
@@ -352,6 +650,9 @@ void registerSystray(void) {
 
   owner = [[SystrayAppDelegate alloc] init];
   [[NSApplication sharedApplication] setDelegate:owner];
+  if (launched) {
+    [owner performSelector:@selector(applicationDidFinishLaunching:) withObject:nil afterDelay:0];
+  }
 
   // A workaround to avoid crashing on macOS versions before Catalina. Somehow
   // SIGSEGV would happen inside AppKit if [NSApp run] is called from a
@@ -387,82 +688,169 @@ void runInMainThread(SEL method, id object) {
                   waitUntilDone: YES];
 }
 
-void setIcon(const char* iconBytes, int length, bool template) {
+// runInTray is like runInMainThread for the icon of a tray, it does nothing until it exists
+void runInTray(int trayId, SEL method, id object) {
+  SystrayIcon *icon;
+  NSMutableDictionary *icons = tray_icons();
+  @synchronized (icons) {
+    icon = [icons objectForKey:[NSNumber numberWithInt:trayId]];
+  }
+  [icon
+    performSelectorOnMainThread:method
+                     withObject:object
+                  waitUntilDone: YES];
+}
+
+void create_tray(int trayId) {
+  runInMainThread(@selector(create_tray:), (id)[NSNumber numberWithInt:trayId]);
+}
+
+void remove_tray(int trayId) {
+  runInMainThread(@selector(remove_tray:), (id)[NSNumber numberWithInt:trayId]);
+}
+
+void set_tray_visible(int trayId, bool visible) {
+  runInTray(trayId, @selector(setVisible:), (id)[NSNumber numberWithBool:visible]);
+}
+
+bool setIcon(int trayId, const char* iconBytes, int length, bool template) {
   NSData* buffer = [NSData dataWithBytes: iconBytes length:length];
   @autoreleasepool {
     NSImage *image = [[NSImage alloc] initWithData:buffer];
+    if (image == nil) {
+      return false;
+    }
     [image setSize:NSMakeSize(16, 16)];
     image.template = template;
-    runInMainThread(@selector(setIcon:), (id)image);
+    runInTray(trayId, @selector(setIcon:), (id)image);
   }
+  return true;
 }
 
-void setMenuItemIcon(const char* iconBytes, int length, int menuId, bool template) {
+bool setAttentionIcon(int trayId, const char* iconBytes, int length, bool template) {
   NSData* buffer = [NSData dataWithBytes: iconBytes length:length];
   @autoreleasepool {
     NSImage *image = [[NSImage alloc] initWithData:buffer];
+    if (image == nil) {
+      return false;
+    }
+    [image setSize:NSMakeSize(16, 16)];
+    image.template = template;
+    runInTray(trayId, @selector(setAttentionIcon:), (id)image);
+  }
+  return true;
+}
+
+bool setOverlayIcon(int trayId, const char* iconBytes, int length) {
+  if (length == 0) {
+    runInTray(trayId, @selector(setOverlayIcon:), nil);
+    return true;
+  }
+  NSData* buffer = [NSData dataWithBytes: iconBytes length:length];
+  @autoreleasepool {
+    NSImage *image = [[NSImage alloc] initWithData:buffer];
+    if (image == nil) {
+      return false;
+    }
+    [image setSize:NSMakeSize(16, 16)];
+    runInTray(trayId, @selector(setOverlayIcon:), (id)image);
+  }
+  return true;
+}
+
+bool setMenuItemIcon(int trayId, const char* iconBytes, int length, int menuId, bool template) {
+  NSData* buffer = [NSData dataWithBytes: iconBytes length:length];
+  @autoreleasepool {
+    NSImage *image = [[NSImage alloc] initWithData:buffer];
+    if (image == nil) {
+      return false;
+    }
     [image setSize:NSMakeSize(16, 16)];
     image.template = template;
     NSNumber *mId = [NSNumber numberWithInt:menuId];
-    runInMainThread(@selector(setMenuItemIcon:), @[image, (id)mId]);
+    runInTray(trayId, @selector(setMenuItemIcon:), @[image, (id)mId]);
   }
+  return true;
+}
+
+void clearMenuItemIcon(int trayId, int menuId) {
+  NSNumber *mId = [NSNumber numberWithInt:menuId];
+  runInTray(trayId, @selector(setMenuItemIcon:), @[[NSNull null], (id)mId]);
 }
 
-void setTitle(char* ctitle) {
+void setTitle(int trayId, char* ctitle) {
   NSString* title = [[NSString alloc] initWithCString:ctitle
                                              encoding:NSUTF8StringEncoding];
   free(ctitle);
-  runInMainThread(@selector(setTitle:), (id)title);
+  runInTray(trayId, @selector(setTitle:), (id)title);
 }
 
-void setTooltip(char* ctooltip) {
+void setTooltip(int trayId, char* ctooltip) {
   NSString* tooltip = [[NSString alloc] initWithCString:ctooltip
                                                encoding:NSUTF8StringEncoding];
   free(ctooltip);
-  runInMainThread(@selector(setTooltip:), (id)tooltip);
+  runInTray(trayId, @selector(setTooltip:), (id)tooltip);
 }
 
-void setRemovalAllowed(bool allowed) {
+void setRemovalAllowed(int trayId, bool allowed) {
   if (allowed) {
-    runInMainThread(@selector(setRemovalAllowed), nil);
+    runInTray(trayId, @selector(setRemovalAllowed), nil);
   } else {
-    runInMainThread(@selector(setRemovalForbidden), nil);
+    runInTray(trayId, @selector(setRemovalForbidden), nil);
   }
 }
 
-void add_or_update_menu_item(int menuId, int parentMenuId, char* title, char* tooltip, short disabled, short checked, short isCheckable) {
-  MenuItem* item = [[MenuItem alloc] initWithId: menuId withParentMenuId: parentMenuId withTitle: title withTooltip: tooltip withDisabled: disabled withChecked: checked];
+void setStatus(int trayId, int status) {
+  runInTray(trayId, @selector(setStatus:), (id)[NSNumber numberWithInt:status]);
+}
+
+void add_or_update_menu_item(int trayId, int menuId, int parentMenuId, char* title, char* tooltip, short disabled, short checked, short isCheckable, char* keyEquivalent, int modifiers, int index) {
+  MenuItem* item = [[MenuItem alloc] initWithId: menuId withParentMenuId: parentMenuId withTitle: title withTooltip: tooltip withDisabled: disabled withChecked: checked withKeyEquivalent: keyEquivalent withModifiers: modifiers withIndex: index];
   free(title);
   free(tooltip);
-  runInMainThread(@selector(add_or_update_menu_item:), (id)item);
+  free(keyEquivalent);
+  runInTray(trayId, @selector(add_or_update_menu_item:), (id)item);
 }
 
-void add_separator(int menuId, int parentId) {
+void add_separator(int trayId, int menuId, int parentId, int index) {
+  NSNumber *mId = [NSNumber numberWithInt:menuId];
   NSNumber *pId = [NSNumber numberWithInt:parentId];
-  runInMainThread(@selector(add_separator:), (id)pId);
+  NSNumber *mIndex = [NSNumber numberWithInt:index];
+  runInTray(trayId, @selector(add_separator:), @[mId, pId, mIndex]);
+}
+
+void ensure_submenu(int trayId, int menuId) {
+  NSNumber *mId = [NSNumber numberWithInt:menuId];
+  runInTray(trayId, @selector(ensure_submenu:), (id)mId);
+}
+
+void hide_menu_item(int trayId, int menuId) {
+  NSNumber *mId = [NSNumber numberWithInt:menuId];
+  runInTray(trayId, @selector(hide_menu_item:), (id)mId);
 }
 
-void hide_menu_item(int menuId) {
+void move_menu_item(int trayId, int menuId, int index) {
   NSNumber *mId = [NSNumber numberWithInt:menuId];
-  runInMainThread(@selector(hide_menu_item:), (id)mId);
+  NSNumber *mIndex = [NSNumber numberWithInt:index];
+  runInTray(trayId, @selector(move_menu_item:), @[mId, mIndex]);
 }
 
-void remove_menu_item(int menuId) {
+void remove_menu_item(int trayId, int menuId) {
   NSNumber *mId = [NSNumber numberWithInt:menuId];
-  runInMainThread(@selector(remove_menu_item:), (id)mId);
+  runInTray(trayId, @selector(remove_menu_item:), (id)mId);
 }
 
-void show_menu() {
-  runInMainThread(@selector(show_menu), nil);
+void show_menu(int trayId) {
+  runInTray(trayId, @selector(show_menu), nil);
 }
 
-void show_menu_item(int menuId) {
+void show_menu_item(int trayId, int menuId) {
   NSNumber *mId = [NSNumber numberWithInt:menuId];
-  runInMainThread(@selector(show_menu_item:), (id)mId);
+  runInTray(trayId, @selector(show_menu_item:), (id)mId);
 }
 
-void reset_menu() {
-  runInMainThread(@selector(reset_menu), nil);
+void reset_menu(int trayId) {
+  runInTray(trayId, @selector(reset_menu), nil);
 }
 
 void quit() {
@@ -0,0 +1,180 @@
+package systray
+
+import "sync"
+
+// OverflowPolicy decides what happens to a click that does not fit in the click queue, see
+// MenuItem.SetClickQueue.
+type OverflowPolicy int
+
+const (
+	// OverflowDropNewest drops the new click, this is the default. Clicks carry no data, so a
+	// full queue of size 1 coalesces the clicks into a single pending notification.
+	OverflowDropNewest OverflowPolicy = iota
+	// OverflowBlock keeps every click, those that do not fit wait for room without blocking the
+	// tray, which goes on handling events.
+	OverflowBlock
+)
+
+// callbacks runs the functions set with MenuItem.OnClick
+var callbacks callbackQueue
+
+// callbackQueue runs functions one after the other on a goroutine, without ever blocking
+// the caller or dropping a function
+type callbackQueue struct {
+	lock    sync.Mutex
+	pending []func()
+	running bool
+}
+
+func (q *callbackQueue) push(f func()) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.pending = append(q.pending, f)
+	if !q.running {
+		q.running = true
+		go q.run()
+	}
+}
+
+// wait returns once the functions pushed so far have been called
+func (q *callbackQueue) wait() {
+	done := make(chan struct{})
+	q.push(func() { close(done) })
+	<-done
+}
+
+func (q *callbackQueue) run() {
+	for {
+		q.lock.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			q.lock.Unlock()
+			return
+		}
+		f := q.pending[0]
+		q.pending[0] = nil
+		q.pending = q.pending[1:]
+		q.lock.Unlock()
+
+		f()
+	}
+}
+
+// OnClick sets a function to be called each time the menu item is clicked, replacing the
+// previous one. Functions are called one after the other on a dedicated goroutine, so that no
+// click is lost while one of them runs. ClickedCh is still notified.
+func (item *MenuItem) OnClick(f func()) {
+	menuItemsLock.Lock()
+	item.onClick = f
+	menuItemsLock.Unlock()
+}
+
+// SetClickQueue keeps up to size clicks waiting until they are received from ClickedCh,
+// applying overflow to the clicks that do not fit. Without it, a click is dropped unless the
+// application is already waiting on ClickedCh. A size below 1 uses a single slot. ClickedCh
+// itself is left unchanged, clicks still waiting in a previous queue are dropped.
+func (item *MenuItem) SetClickQueue(size int, overflow OverflowPolicy) {
+	if size < 1 {
+		size = 1
+	}
+	q := &clickQueue{item: item, size: size, overflow: overflow}
+
+	menuItemsLock.Lock()
+	defer menuItemsLock.Unlock()
+	if _, exists := menuItems[item.id]; !exists {
+		return
+	}
+	if item.clicks != nil {
+		item.clicks.stop()
+	}
+	item.clicks = q
+}
+
+// deliverClick notifies the OnClick function of a click and ClickedCh, or the click queue of
+// the item. menuItemsLock must be held for reading.
+func (item *MenuItem) deliverClick() {
+	if f := item.onClick; f != nil {
+		callbacks.push(f)
+	}
+	if item.clicks != nil {
+		item.clicks.push()
+	} else {
+		notify(item.ClickedCh)
+	}
+}
+
+// clickQueue holds the clicks of an item until they are received from ClickedCh, sending
+// them one after the other on a goroutine
+type clickQueue struct {
+	item     *MenuItem
+	size     int
+	overflow OverflowPolicy
+	lock     sync.Mutex
+	pending  int
+	sending  bool
+	stopped  bool
+}
+
+// push adds a click to the queue, it never blocks
+func (q *clickQueue) push() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	// with OverflowBlock, the clicks beyond the size are the ones waiting for room
+	if q.stopped || q.overflow != OverflowBlock && q.pending >= q.size {
+		return
+	}
+	q.pending++
+	if !q.sending {
+		q.sending = true
+		go q.send()
+	}
+}
+
+func (q *clickQueue) send() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for q.pending > 0 && !q.stopped {
+		q.lock.Unlock()
+		sent := q.item.sendClick()
+		q.lock.Lock()
+		if !sent {
+			break
+		}
+		q.pending--
+	}
+	q.sending = false
+}
+
+// stop drops the pending clicks
+func (q *clickQueue) stop() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.stopped = true
+	q.pending = 0
+}
+
+// len returns the number of clicks waiting to be received
+func (q *clickQueue) len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.pending
+}
+
+// sendClick waits until a click is received from ClickedCh, it returns false if the item is
+// removed first
+func (item *MenuItem) sendClick() bool {
+	item.sendLock.Lock()
+	defer item.sendLock.Unlock()
+	select {
+	case <-item.removed:
+		return false
+	default:
+	}
+
+	select {
+	case item.ClickedCh <- struct{}{}:
+		return true
+	case <-item.removed:
+		return false
+	}
+}
@@ -0,0 +1,336 @@
+package systray
+
+import (
+	"errors"
+	"sync"
+	_ "unsafe" // for go:linkname
+
+	"fyne.io/systray/internal/fake"
+)
+
+// recordedTray is the state of a tray recorded by recordingBackend
+type recordedTray struct {
+	icon, attentionIcon, overlayIcon             []byte
+	iconName, attentionIconName, overlayIconName string
+	iconThemePath                                string
+	title, tooltip                               string
+	status                                       Status
+	// menuOpen is set while the top level menu is opened by the test
+	menuOpen bool
+}
+
+//go:linkname fakeEvent fyne.io/systray/internal/fake.Event
+func fakeEvent(tray interface{}, id uint32, kind int) {
+	t := headlessTray(tray)
+	switch {
+	case MenuEventKind(kind) == MenuEventOpened && id != 0:
+		systrayMenuAboutToShow(id)
+	case MenuEventKind(kind) == MenuEventOpened:
+		recording().record(t, func(r *recordedTray) { r.menuOpen = true })
+	case MenuEventKind(kind) == MenuEventClosed && id == 0:
+		recording().record(t, func(r *recordedTray) { r.menuOpen = false })
+	}
+	systrayMenuEvent(t, id, MenuEvent{Kind: MenuEventKind(kind)})
+	callbacks.wait()
+}
+
+//go:linkname fakeTap fyne.io/systray/internal/fake.Tap
+func fakeTap(tray interface{}, button, x, y int) bool {
+	t := headlessTray(tray)
+	var f func(x, y int)
+	switch button {
+	case fake.Primary:
+		f = t.tappedLeftHandler()
+	case fake.Secondary:
+		f = t.tappedRightHandler()
+	case fake.Middle:
+		f = t.tappedMiddleHandler()
+	}
+	if f == nil {
+		return false
+	}
+	f(x, y)
+	return true
+}
+
+//go:linkname fakeScroll fyne.io/systray/internal/fake.Scroll
+func fakeScroll(tray interface{}, delta, orientation int) bool {
+	f := headlessTray(tray).scrollHandler()
+	if f == nil {
+		return false
+	}
+	f(delta, Orientation(orientation))
+	return true
+}
+
+//go:linkname fakeQuitted fyne.io/systray/internal/fake.Quitted
+func fakeQuitted() bool {
+	return quitCalled.Load()
+}
+
+//go:linkname fakeState fyne.io/systray/internal/fake.State
+func fakeState(tray interface{}) fake.Tray {
+	return recording().state(headlessTray(tray))
+}
+
+// headlessTray returns the tray held by tray, or the default tray for nil
+func headlessTray(tray interface{}) *Tray {
+	if t, _ := tray.(*Tray); t != nil {
+		return t
+	}
+	return defaultTray
+}
+
+// recording returns the recording backend, nil once the platform backend is restored
+func recording() *recordingBackend {
+	r, _ := currentBackend().(*recordingBackend)
+	return r
+}
+
+//go:linkname startHeadless fyne.io/systray/internal/fake.Start
+func startHeadless(onReady, onExit func()) error {
+	activeBackendLock.Lock()
+	if _, recording := activeBackend.(*recordingBackend); recording {
+		activeBackendLock.Unlock()
+		return errors.New("the backend is already replaced")
+	}
+	activeBackend = &recordingBackend{
+		trays: make(map[uint32]*recordedTray),
+		items: make(map[uint32]*recordedItem),
+	}
+	activeBackendLock.Unlock()
+
+	setCallbacks(onReady, onExit)
+	systrayReady()
+	initialMenuBuilt.Wait()
+	return nil
+}
+
+// stopHeadless quits and forgets the trays and the callbacks of the test, so that the next
+// one starts afresh
+//
+//go:linkname stopHeadless fyne.io/systray/internal/fake.Stop
+func stopHeadless() {
+	Quit()
+	for _, t := range currentTrays() {
+		if t != defaultTray {
+			t.Remove()
+		}
+	}
+	t := defaultTray
+	t.hidden.Store(false)
+	t.setHandlers(func(h *trayHandlers) { *h = trayHandlers{} })
+
+	activeBackendLock.Lock()
+	activeBackend = nativeBackend{}
+	activeBackendLock.Unlock()
+}
+
+// recordingBackend replaces the platform backend while the systraytest package runs,
+// recording the state of the trays rather than showing them. The menus are recorded by the
+// platform independent code already.
+type recordingBackend struct {
+	// trays and items hold the recorded state keyed by the ID of the tray or of the item,
+	// they are protected by lock
+	trays map[uint32]*recordedTray
+	items map[uint32]*recordedItem
+	lock  sync.Mutex
+}
+
+// recordedItem is the state of a menu item recorded by recordingBackend
+type recordedItem struct {
+	icon     []byte
+	iconName string
+}
+
+// record applies f to the recorded state of the tray, it does nothing on a nil backend
+func (b *recordingBackend) record(t *Tray, f func(r *recordedTray)) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	r, ok := b.trays[t.id]
+	if !ok {
+		r = &recordedTray{}
+		b.trays[t.id] = r
+	}
+	f(r)
+	b.lock.Unlock()
+}
+
+// recordItem applies f to the recorded state of the item
+func (b *recordingBackend) recordItem(item *MenuItem, f func(r *recordedItem)) {
+	b.lock.Lock()
+	r, ok := b.items[item.id]
+	if !ok {
+		r = &recordedItem{}
+		b.items[item.id] = r
+	}
+	f(r)
+	b.lock.Unlock()
+}
+
+// recordIcon records the icon set by f if iconBytes can be decoded, empty iconBytes remove it
+func (b *recordingBackend) recordIcon(t *Tray, iconBytes []byte, f func(r *recordedTray)) error {
+	if len(iconBytes) > 0 {
+		if err := checkIcon(iconBytes); err != nil {
+			return err
+		}
+	}
+	b.record(t, f)
+	return nil
+}
+
+func (b *recordingBackend) setIcon(t *Tray, iconBytes []byte) error {
+	return b.recordIcon(t, iconBytes, func(r *recordedTray) { r.icon = iconBytes })
+}
+
+// setTemplateIcon records the icon shown on most platforms, the regular one
+func (b *recordingBackend) setTemplateIcon(t *Tray, templateIconBytes, regularIconBytes []byte) error {
+	return b.setIcon(t, regularIconBytes)
+}
+
+func (b *recordingBackend) setAttentionIcon(t *Tray, iconBytes []byte) error {
+	return b.recordIcon(t, iconBytes, func(r *recordedTray) { r.attentionIcon = iconBytes })
+}
+
+func (b *recordingBackend) setOverlayIcon(t *Tray, iconBytes []byte) error {
+	return b.recordIcon(t, iconBytes, func(r *recordedTray) { r.overlayIcon = iconBytes })
+}
+
+func (b *recordingBackend) setIconName(t *Tray, name string) error {
+	b.record(t, func(r *recordedTray) { r.iconName = name })
+	return nil
+}
+
+func (b *recordingBackend) setAttentionIconName(t *Tray, name string) error {
+	b.record(t, func(r *recordedTray) { r.attentionIconName = name })
+	return nil
+}
+
+func (b *recordingBackend) setOverlayIconName(t *Tray, name string) error {
+	b.record(t, func(r *recordedTray) { r.overlayIconName = name })
+	return nil
+}
+
+func (b *recordingBackend) setIconThemePath(t *Tray, dir string) error {
+	b.record(t, func(r *recordedTray) { r.iconThemePath = dir })
+	return nil
+}
+
+func (b *recordingBackend) setTitle(t *Tray, title string) error {
+	b.record(t, func(r *recordedTray) { r.title = title })
+	return nil
+}
+
+func (b *recordingBackend) setTooltip(t *Tray, tooltip string) error {
+	b.record(t, func(r *recordedTray) { r.tooltip = tooltip })
+	return nil
+}
+
+func (b *recordingBackend) setStatus(t *Tray, status Status) error {
+	b.record(t, func(r *recordedTray) { r.status = status })
+	return nil
+}
+
+func (b *recordingBackend) setMenuItemIcon(item *MenuItem, iconBytes []byte) error {
+	if err := checkIcon(iconBytes); err != nil {
+		return err
+	}
+	b.recordItem(item, func(r *recordedItem) { r.icon = iconBytes })
+	return nil
+}
+
+func (b *recordingBackend) setMenuItemTemplateIcon(item *MenuItem, templateIconBytes, regularIconBytes []byte) error {
+	return b.setMenuItemIcon(item, regularIconBytes)
+}
+
+func (b *recordingBackend) setMenuItemIconName(item *MenuItem, name string) {
+	b.recordItem(item, func(r *recordedItem) { r.iconName = name })
+}
+
+func (b *recordingBackend) clearMenuItemIcon(item *MenuItem) {
+	b.recordItem(item, func(r *recordedItem) { r.icon = nil })
+}
+
+func (b *recordingBackend) addOrUpdateMenuItem(*MenuItem)         {}
+func (b *recordingBackend) hideMenuItem(*MenuItem)                {}
+func (b *recordingBackend) showMenuItem(*MenuItem)                {}
+func (b *recordingBackend) moveMenuItem(*MenuItem)                {}
+func (b *recordingBackend) addSeparator(*Tray, uint32, uint32)    {}
+func (b *recordingBackend) removeSeparator(*Tray, uint32, uint32) {}
+func (b *recordingBackend) resetMenu(*Tray)                       {}
+func (b *recordingBackend) beginMenuBatch(*Tray)                  {}
+func (b *recordingBackend) batchMenuItem(*MenuItem)               {}
+func (b *recordingBackend) endMenuBatch(*Tray)                    {}
+
+func (b *recordingBackend) removeMenuItem(item *MenuItem) {
+	b.lock.Lock()
+	delete(b.items, item.id)
+	b.lock.Unlock()
+}
+
+// quit ends the recording backend like the platform ones do, with empty menus
+func (b *recordingBackend) quit() {
+	runSystrayExit()
+	for _, t := range currentTrays() {
+		t.ResetMenu()
+	}
+}
+
+// state returns the recorded state of the tray, an empty one on a nil backend
+func (b *recordingBackend) state(t *Tray) fake.Tray {
+	if b == nil {
+		return fake.Tray{}
+	}
+	menuItemsLock.RLock()
+	defer menuItemsLock.RUnlock()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	state := fake.Tray{Hidden: t.hidden.Load()}
+	if r, ok := b.trays[t.id]; ok {
+		state.Icon = r.icon
+		state.AttentionIcon = r.attentionIcon
+		state.OverlayIcon = r.overlayIcon
+		state.IconName = r.iconName
+		state.AttentionIconName = r.attentionIconName
+		state.OverlayIconName = r.overlayIconName
+		state.IconThemePath = r.iconThemePath
+		state.Title = r.title
+		state.Tooltip = r.tooltip
+		state.Status = int(r.status)
+		state.MenuOpen = r.menuOpen
+	}
+	state.Menu = b.menu(t, 0)
+	return state
+}
+
+// menu returns the state of the menu of the item with the ID, or of the top level menu for 0.
+// menuItemsLock and b.lock must be held.
+func (b *recordingBackend) menu(t *Tray, parent uint32) []fake.Item {
+	ids := t.menuOrder[parent]
+	items := make([]fake.Item, 0, len(ids))
+	for _, id := range ids {
+		item, ok := menuItems[id]
+		if !ok {
+			items = append(items, fake.Item{ID: id, Separator: true})
+			continue
+		}
+		recorded := fake.Item{
+			ID:        id,
+			Title:     item.title,
+			Tooltip:   item.tooltip,
+			Disabled:  item.disabled,
+			Hidden:    item.hidden,
+			Checkable: item.isCheckable || item.radioGroup != "",
+			Checked:   item.checked,
+			Items:     b.menu(t, id),
+		}
+		if r, ok := b.items[id]; ok {
+			recorded.Icon = r.icon
+			recorded.IconName = r.iconName
+		}
+		items = append(items, recorded)
+	}
+	return items
+}
@@ -0,0 +1,306 @@
+package systray
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg" // register JPEG decoding for icons
+	"image/png"    // used to embed and extract PNG images in .ico files
+	"math"
+	"sort"
+)
+
+// standardIconSizes are the sizes generated from a single large icon image.
+var standardIconSizes = []int{16, 22, 24, 32, 48, 64}
+
+var errInvalidICO = errors.New("invalid .ico data")
+
+// SetIconSet sets the tray icon from images of different sizes, keyed by their size
+// in pixels, so that the best one can be used on every display density.
+// Each image should be the content of a .png file whose larger side is its key.
+// The images are used as given, no other size is generated.
+func (t *Tray) SetIconSet(icons map[int][]byte) {
+	logFailure("failed to set icon set", t.TrySetIconSet(icons), "tray", t.id)
+}
+
+// TrySetIconSet is like SetIconSet but returns an error on failure.
+func (t *Tray) TrySetIconSet(icons map[int][]byte) error {
+	data, err := encodeICO(icons)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidIcon, err)
+	}
+	return t.TrySetIcon(data)
+}
+
+// checkIcon returns an error wrapping ErrInvalidIcon if data isn't a .ico, .png or .jpg image
+func checkIcon(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("%w: no data", ErrInvalidIcon)
+	}
+	if _, err := decodeIcon(data); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidIcon, err)
+	}
+	return nil
+}
+
+// encodeICO packs the images into a .ico file using PNG compressed entries,
+// which is understood by every platform.
+func encodeICO(icons map[int][]byte) ([]byte, error) {
+	sizes := make([]int, 0, len(icons))
+	for size := range icons {
+		sizes = append(sizes, size)
+	}
+	sort.Ints(sizes)
+
+	entries := make([][]byte, 0, len(sizes))
+	bounds := make([]image.Rectangle, 0, len(sizes))
+	for _, size := range sizes {
+		img, _, err := image.Decode(bytes.NewReader(icons[size]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %dpx icon: %v", size, err)
+		}
+		if b := img.Bounds(); largestSide(b) != size {
+			return nil, fmt.Errorf("the %dpx icon is %dx%d", size, b.Dx(), b.Dy())
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, fmt.Errorf("failed to encode %dpx icon: %v", size, err)
+		}
+		entries = append(entries, buf.Bytes())
+		bounds = append(bounds, img.Bounds())
+	}
+
+	const headerSize, entrySize = 6, 16
+	var out bytes.Buffer
+	binary.Write(&out, binary.LittleEndian, [3]uint16{0, 1, uint16(len(entries))})
+	offset := headerSize + entrySize*len(entries)
+	for i, data := range entries {
+		binary.Write(&out, binary.LittleEndian, struct {
+			Width, Height, Colors, Reserved uint8
+			Planes, BitCount                uint16
+			Size, Offset                    uint32
+		}{
+			Width:    icoDimension(bounds[i].Dx()),
+			Height:   icoDimension(bounds[i].Dy()),
+			Planes:   1,
+			BitCount: 32,
+			Size:     uint32(len(data)),
+			Offset:   uint32(offset),
+		})
+		offset += len(data)
+	}
+	for _, data := range entries {
+		out.Write(data)
+	}
+	return out.Bytes(), nil
+}
+
+// icoDimension returns the value stored in a .ico directory entry, where 0 means 256 or more.
+func icoDimension(size int) uint8 {
+	if size >= 256 {
+		return 0
+	}
+	return uint8(size)
+}
+
+func isICO(data []byte) bool {
+	return len(data) >= 6 && bytes.Equal(data[:4], []byte{0, 0, 1, 0}) &&
+		binary.LittleEndian.Uint16(data[4:6]) > 0
+}
+
+// decodeIcon returns every image contained in data, which may be a .ico file
+// or any registered image format.
+func decodeIcon(data []byte) ([]image.Image, error) {
+	if isICO(data) {
+		return decodeICO(data)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return []image.Image{img}, nil
+}
+
+// checkICO returns an error wrapping ErrInvalidIcon unless data is a .ico file with a well
+// formed directory. The entries themselves are left to the platform to decode.
+func checkICO(data []byte) error {
+	if !isICO(data) {
+		return fmt.Errorf("%w: .ico data expected", ErrInvalidIcon)
+	}
+	if _, err := icoEntries(data); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidIcon, err)
+	}
+	return nil
+}
+
+// icoEntries returns the image data of every entry of a .ico file, checking that the
+// directory points within data.
+func icoEntries(data []byte) ([][]byte, error) {
+	count := int(binary.LittleEndian.Uint16(data[4:6]))
+	if len(data) < 6+16*count {
+		return nil, errInvalidICO
+	}
+
+	entries := make([][]byte, count)
+	for i := range entries {
+		entry := data[6+16*i:]
+		size := binary.LittleEndian.Uint32(entry[8:12])
+		offset := binary.LittleEndian.Uint32(entry[12:16])
+		if size == 0 || uint64(offset)+uint64(size) > uint64(len(data)) {
+			return nil, errInvalidICO
+		}
+		entries[i] = data[offset : offset+size]
+	}
+	return entries, nil
+}
+
+// decodeICO returns the images of a .ico file, skipping entries in unsupported formats.
+func decodeICO(data []byte) ([]image.Image, error) {
+	entries, err := icoEntries(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var imgs []image.Image
+	for _, entry := range entries {
+		img, err := decodeICOEntry(entry)
+		if err != nil {
+			continue
+		}
+		imgs = append(imgs, img)
+	}
+	if len(imgs) == 0 {
+		return nil, errInvalidICO
+	}
+	return imgs, nil
+}
+
+// decodeICOEntry decodes a PNG entry or a 24 or 32 bit uncompressed bitmap entry.
+func decodeICOEntry(data []byte) (image.Image, error) {
+	if bytes.HasPrefix(data, []byte("\x89PNG")) {
+		return png.Decode(bytes.NewReader(data))
+	}
+
+	if len(data) < 40 {
+		return nil, errInvalidICO
+	}
+	headerSize := int(binary.LittleEndian.Uint32(data[0:4]))
+	w := int(int32(binary.LittleEndian.Uint32(data[4:8])))
+	h := int(int32(binary.LittleEndian.Uint32(data[8:12]))) / 2 // includes the AND mask
+	bitCount := int(binary.LittleEndian.Uint16(data[14:16]))
+	compression := binary.LittleEndian.Uint32(data[16:20])
+	if w <= 0 || h <= 0 || compression != 0 || (bitCount != 24 && bitCount != 32) {
+		return nil, fmt.Errorf("unsupported .ico bitmap entry")
+	}
+	if headerSize < 40 || headerSize > len(data) {
+		return nil, errInvalidICO
+	}
+
+	stride := (w*bitCount + 31) / 32 * 4
+	maskStride := (w + 31) / 32 * 4
+	pixels := data[headerSize:]
+	if len(pixels) < stride*h {
+		return nil, errInvalidICO
+	}
+	mask := pixels[stride*h:]
+	hasMask := len(mask) >= maskStride*h
+
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	hasAlpha := false
+	for y := 0; y < h; y++ {
+		row := pixels[(h-1-y)*stride:] // rows are stored bottom-up
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{B: row[x*bitCount/8], G: row[x*bitCount/8+1], R: row[x*bitCount/8+2], A: 0xff}
+			if bitCount == 32 {
+				c.A = row[x*4+3]
+				hasAlpha = hasAlpha || c.A != 0
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	if hasAlpha || !hasMask {
+		return img, nil
+	}
+
+	for y := 0; y < h; y++ {
+		row := mask[(h-1-y)*maskStride:]
+		for x := 0; x < w; x++ {
+			if row[x/8]&(0x80>>(x%8)) != 0 {
+				img.Pix[img.PixOffset(x, y)+3] = 0
+			} else {
+				img.Pix[img.PixOffset(x, y)+3] = 0xff
+			}
+		}
+	}
+	return img, nil
+}
+
+// withStandardSizes returns img together with downscaled copies for every standard
+// icon size smaller than it.
+func withStandardSizes(img image.Image) []image.Image {
+	largest := largestSide(img.Bounds())
+	var imgs []image.Image
+	for _, size := range standardIconSizes {
+		if size < largest {
+			imgs = append(imgs, scaleImage(img, size))
+		}
+	}
+	return append(imgs, img)
+}
+
+// largestSide returns the width or the height of b, whichever is larger
+func largestSide(b image.Rectangle) int {
+	if b.Dy() > b.Dx() {
+		return b.Dy()
+	}
+	return b.Dx()
+}
+
+// scaleImage shrinks img so that its larger side is size pixels, preserving the aspect ratio.
+// Each destination pixel is the area weighted average of the source pixels it covers.
+func scaleImage(img image.Image, size int) *image.RGBA {
+	b := img.Bounds()
+	scale := float64(b.Dx()) / float64(size)
+	if b.Dy() > b.Dx() {
+		scale = float64(b.Dy()) / float64(size)
+	}
+	w := int(math.Max(1, math.Round(float64(b.Dx())/scale)))
+	h := int(math.Max(1, math.Round(float64(b.Dy())/scale)))
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		sy0, sy1 := float64(y)*scale, math.Min(float64(y+1)*scale, float64(b.Dy()))
+		for x := 0; x < w; x++ {
+			sx0, sx1 := float64(x)*scale, math.Min(float64(x+1)*scale, float64(b.Dx()))
+
+			var r, g, bl, a, total float64
+			for sy := int(sy0); float64(sy) < sy1; sy++ {
+				wy := math.Min(sy1, float64(sy+1)) - math.Max(sy0, float64(sy))
+				for sx := int(sx0); float64(sx) < sx1; sx++ {
+					wx := math.Min(sx1, float64(sx+1)) - math.Max(sx0, float64(sx))
+					// RGBA returns premultiplied values, so transparent pixels don't bleed color
+					cr, cg, cb, ca := img.At(b.Min.X+sx, b.Min.Y+sy).RGBA()
+					weight := wx * wy
+					r += float64(cr) * weight
+					g += float64(cg) * weight
+					bl += float64(cb) * weight
+					a += float64(ca) * weight
+					total += weight
+				}
+			}
+			if total == 0 {
+				continue
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(math.Round(r / total / 0x101)),
+				G: uint8(math.Round(g / total / 0x101)),
+				B: uint8(math.Round(bl / total / 0x101)),
+				A: uint8(math.Round(a / total / 0x101)),
+			})
+		}
+	}
+	return dst
+}
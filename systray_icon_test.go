@@ -0,0 +1,145 @@
+package systray
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func solidPNG(t *testing.T, size int, c color.Color) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode failed: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestEncodeDecodeICO(t *testing.T) {
+	red := color.NRGBA{R: 0xff, A: 0xff}
+	data, err := encodeICO(map[int][]byte{
+		32: solidPNG(t, 32, red),
+		16: solidPNG(t, 16, red),
+	})
+	if err != nil {
+		t.Fatalf("encodeICO failed: %s", err)
+	}
+	if !isICO(data) {
+		t.Fatal("encodeICO must produce .ico data")
+	}
+
+	imgs, err := decodeIcon(data)
+	if err != nil {
+		t.Fatalf("decodeIcon failed: %s", err)
+	}
+	if len(imgs) != 2 {
+		t.Fatalf("expected 2 images, got %d", len(imgs))
+	}
+	if w := imgs[0].Bounds().Dx(); w != 16 {
+		t.Errorf("expected first image to be 16px, got %d", w)
+	}
+	if w := imgs[1].Bounds().Dx(); w != 32 {
+		t.Errorf("expected second image to be 32px, got %d", w)
+	}
+}
+
+func TestDecodeICOBitmapEntry(t *testing.T) {
+	// 2x1 32 bit bitmap, stored bottom-up as BGRA with a doubled height
+	entry := []byte{
+		40, 0, 0, 0, 2, 0, 0, 0, 2, 0, 0, 0, 1, 0, 32, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0,
+		0x00, 0x00, 0xff, 0xff, 0xff, 0x00, 0x00, 0x80,
+		0, 0, 0, 0, // AND mask
+	}
+	img, err := decodeICOEntry(entry)
+	if err != nil {
+		t.Fatalf("decodeICOEntry failed: %s", err)
+	}
+	if got := color.NRGBAModel.Convert(img.At(0, 0)); got != (color.NRGBA{R: 0xff, A: 0xff}) {
+		t.Errorf("unexpected first pixel %v", got)
+	}
+	if got := color.NRGBAModel.Convert(img.At(1, 0)); got != (color.NRGBA{B: 0xff, A: 0x80}) {
+		t.Errorf("unexpected second pixel %v", got)
+	}
+}
+
+func TestWithStandardSizes(t *testing.T) {
+	src, _ := png.Decode(bytes.NewReader(solidPNG(t, 40, color.NRGBA{G: 0xff, A: 0xff})))
+
+	imgs := withStandardSizes(src)
+	var sizes []int
+	for _, img := range imgs {
+		sizes = append(sizes, img.Bounds().Dx())
+	}
+	expected := []int{16, 22, 24, 32, 40}
+	if len(sizes) != len(expected) {
+		t.Fatalf("expected sizes %v, got %v", expected, sizes)
+	}
+	for i := range expected {
+		if sizes[i] != expected[i] {
+			t.Fatalf("expected sizes %v, got %v", expected, sizes)
+		}
+	}
+
+	r, g, b, a := imgs[0].At(7, 7).RGBA()
+	if r != 0 || g != 0xffff || b != 0 || a != 0xffff {
+		t.Errorf("scaling must preserve a solid color, got %d %d %d %d", r, g, b, a)
+	}
+}
+
+func TestCheckIcon(t *testing.T) {
+	if err := checkIcon(solidPNG(t, 16, color.White)); err != nil {
+		t.Errorf("expected PNG data to be accepted, got %s", err)
+	}
+	// a single 40 byte bitmap entry whose header claims to be larger than the entry
+	oversized := []byte{
+		0, 0, 1, 0, 1, 0,
+		1, 1, 0, 0, 1, 0, 32, 0, 40, 0, 0, 0, 22, 0, 0, 0,
+		0xff, 0xff, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 1, 0, 32, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0,
+	}
+	for _, data := range [][]byte{nil, []byte("not an image"), oversized} {
+		if err := checkIcon(data); !errors.Is(err, ErrInvalidIcon) {
+			t.Errorf("expected ErrInvalidIcon for %q, got %v", data, err)
+		}
+	}
+	if err := TrySetIconSet(map[int][]byte{16: []byte("not an image")}); !errors.Is(err, ErrInvalidIcon) {
+		t.Errorf("expected TrySetIconSet to return ErrInvalidIcon, got %v", err)
+	}
+	if err := TrySetIconSet(map[int][]byte{16: solidPNG(t, 32, color.White)}); !errors.Is(err, ErrInvalidIcon) {
+		t.Errorf("expected TrySetIconSet to reject an image not matching its size, got %v", err)
+	}
+}
+
+func TestCheckICO(t *testing.T) {
+	// a 1x1 8 bit palette entry, which is only decoded by the platform
+	palette := []byte{
+		0, 0, 1, 0, 1, 0,
+		1, 1, 0, 0, 1, 0, 8, 0, 48, 0, 0, 0, 22, 0, 0, 0,
+		40, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 1, 0, 8, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0xff, 0, // palette
+		0, 0, 0, 0, // pixels
+	}
+	if err := checkICO(palette); err != nil {
+		t.Errorf("expected a well formed directory to be accepted, got %s", err)
+	}
+
+	truncated := palette[:len(palette)-1]
+	for _, data := range [][]byte{nil, solidPNG(t, 16, color.White), truncated, palette[:20]} {
+		if err := checkICO(data); !errors.Is(err, ErrInvalidIcon) {
+			t.Errorf("expected ErrInvalidIcon for %v, got %v", data, err)
+		}
+	}
+}
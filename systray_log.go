@@ -0,0 +1,92 @@
+package systray
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Logger receives the failures reported by the package, see SetLogger.
+// The arguments following the message are alternating keys and values describing the context,
+// such as "item" with the ID of a menu item, "method" with a D-Bus method or "error".
+// It is satisfied by *slog.Logger.
+type Logger interface {
+	Error(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+}
+
+var (
+	logger     Logger = stdLogger{}
+	loggerLock sync.RWMutex
+)
+
+// SetLogger sets the logger receiving the failures reported by the package, they are written
+// with the standard log package by default. A nil logger silences them.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = discardLogger{}
+	}
+	loggerLock.Lock()
+	logger = l
+	loggerLock.Unlock()
+}
+
+func logError(msg string, args ...interface{}) {
+	loggerLock.RLock()
+	l := logger
+	loggerLock.RUnlock()
+	l.Error(msg, args...)
+}
+
+func logWarn(msg string, args ...interface{}) {
+	loggerLock.RLock()
+	l := logger
+	loggerLock.RUnlock()
+	l.Warn(msg, args...)
+}
+
+// logFailure reports the error returned by the Try counterpart of a function that has no error
+// result.
+func logFailure(msg string, err error, args ...interface{}) {
+	if err == nil {
+		return
+	}
+	logError(msg, append(args, "error", err)...)
+}
+
+// stdLogger writes lines such as "systray error: failed to set icon: <error> item=3" with the log package
+type stdLogger struct{}
+
+func (stdLogger) Error(msg string, args ...interface{}) {
+	log.Print("systray error: " + formatLog(msg, args))
+}
+
+func (stdLogger) Warn(msg string, args ...interface{}) {
+	log.Print("systray warning: " + formatLog(msg, args))
+}
+
+func formatLog(msg string, args []interface{}) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	var attrs strings.Builder
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			fmt.Fprintf(&attrs, " %v", args[i])
+			break
+		}
+		if args[i] == "error" {
+			fmt.Fprintf(&b, ": %v", args[i+1])
+			continue
+		}
+		fmt.Fprintf(&attrs, " %v=%v", args[i], args[i+1])
+	}
+	b.WriteString(attrs.String())
+	return b.String()
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Error(string, ...interface{}) {}
+
+func (discardLogger) Warn(string, ...interface{}) {}
@@ -0,0 +1,40 @@
+package systray
+
+import (
+	"errors"
+	"testing"
+)
+
+type recordingLogger struct {
+	errors []string
+}
+
+func (l *recordingLogger) Error(msg string, args ...interface{}) {
+	l.errors = append(l.errors, formatLog(msg, args))
+}
+
+func (l *recordingLogger) Warn(string, ...interface{}) {}
+
+func TestSetLogger(t *testing.T) {
+	defer SetLogger(stdLogger{})
+
+	l := &recordingLogger{}
+	SetLogger(l)
+	systrayMenuItemSelected(0xFFFFFF)
+	if len(l.errors) != 1 || l.errors[0] != "no such menu item item=16777215" {
+		t.Errorf("unexpected errors %q", l.errors)
+	}
+
+	SetLogger(nil)
+	systrayMenuItemSelected(0xFFFFFF)
+	if len(l.errors) != 1 {
+		t.Error("expected no more errors once silenced")
+	}
+}
+
+func TestFormatLog(t *testing.T) {
+	got := formatLog("failed to set property", []interface{}{"property", "Title", "error", errors.New("boom")})
+	if expected := "failed to set property: boom property=Title"; got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
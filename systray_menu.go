@@ -0,0 +1,220 @@
+package systray
+
+import "bytes"
+
+// Menu describes the whole menu of a tray icon, see Tray.SetMenu.
+type Menu struct {
+	Items []MenuEntry
+}
+
+// MenuEntry describes an item or a separator of a Menu.
+type MenuEntry struct {
+	// Key identifies the entry between calls to SetMenu so that its menu item is updated in
+	// place rather than replaced. It defaults to the title, entries whose title changes should
+	// set a stable key.
+	Key string
+	// Separator makes the entry a separator bar, other fields are then ignored.
+	Separator bool
+
+	Title   string
+	Tooltip string
+	// Icon is the content of .ico/.jpg/.png, see MenuItem.SetIcon.
+	Icon     []byte
+	Disabled bool
+	Hidden   bool
+	// Checkable adds a checkbox to the item on Linux, see AddMenuItemCheckbox.
+	Checkable bool
+	Checked   bool
+	// RadioGroup makes the item part of a group of mutually exclusive items, see AddMenuItemRadio.
+	RadioGroup        string
+	ShortcutModifiers Modifier
+	ShortcutKey       string
+
+	// OnClick is called when the item is clicked.
+	OnClick func()
+	// Items are the entries of the sub-menu of the item.
+	Items []MenuEntry
+}
+
+func (e *MenuEntry) key() string {
+	if e.Key != "" {
+		return e.Key
+	}
+	return e.Title
+}
+
+// menuNode is an entry applied by SetMenu along with the item or separator displaying it
+type menuNode struct {
+	key   string
+	entry MenuEntry
+	// item is nil for separators
+	item        *MenuItem
+	separatorID uint32
+	children    []*menuNode
+}
+
+// SetMenu sets the menu to the one described, so that the menu can be rendered from the
+// application state each time it changes. Entries are matched by key with those of the
+// previous call, only the items that were added, removed, moved or changed are updated.
+// SetMenu manages the whole menu, items added by other means are removed on the first call.
+// It can be safely invoked from different goroutines.
+func (t *Tray) SetMenu(menu Menu) {
+	t.declaredMenuLock.Lock()
+	defer t.declaredMenuLock.Unlock()
+
+	if !t.menuDeclared {
+		menuItemsLock.RLock()
+		empty := len(t.menuOrder[0]) == 0
+		menuItemsLock.RUnlock()
+		if !empty {
+			t.resetMenuItems()
+		}
+		t.menuDeclared = true
+	}
+	currentBackend().beginMenuBatch(t)
+	defer currentBackend().endMenuBatch(t)
+	t.declaredMenu = t.reconcileMenu(nil, t.declaredMenu, menu.Items)
+}
+
+// forgetDeclaredMenu drops the state of SetMenu once the menu has been reset
+func (t *Tray) forgetDeclaredMenu() {
+	t.declaredMenuLock.Lock()
+	t.declaredMenu = nil
+	t.menuDeclared = false
+	t.declaredMenuLock.Unlock()
+}
+
+// reconcileMenu updates the menu of parent, or the top level menu if nil, from the nodes of
+// the previous call to the entries and returns the new nodes. declaredMenuLock must be held.
+func (t *Tray) reconcileMenu(parent *MenuItem, old []*menuNode, entries []MenuEntry) []*menuNode {
+	var parentID uint32
+	if parent != nil {
+		parentID = parent.id
+	}
+
+	// match items by key, in order among the items sharing a key, and separators by position
+	// among separators
+	byKey := make(map[string][]*menuNode, len(old))
+	var separators []*menuNode
+	for _, n := range old {
+		if n.item == nil {
+			separators = append(separators, n)
+		} else if n.item.exists() {
+			byKey[n.key] = append(byKey[n.key], n)
+		}
+	}
+	nodes := make([]*menuNode, len(entries))
+	matchedSeparators := 0
+	for i := range entries {
+		e := &entries[i]
+		if e.Separator {
+			if matchedSeparators < len(separators) {
+				nodes[i] = separators[matchedSeparators]
+				matchedSeparators++
+			}
+			continue
+		}
+		if matches := byKey[e.key()]; len(matches) > 0 {
+			nodes[i] = matches[0]
+			byKey[e.key()] = matches[1:]
+		}
+	}
+
+	for _, unmatched := range byKey {
+		for _, n := range unmatched {
+			n.item.Remove()
+		}
+	}
+	for _, n := range separators[matchedSeparators:] {
+		n.removeSeparator(t, parentID)
+	}
+
+	for i := range entries {
+		e := entries[i]
+		n := nodes[i]
+		switch {
+		case e.Separator && n == nil:
+			n = &menuNode{separatorID: t.newSeparatorID(parentID, i)}
+			currentBackend().addSeparator(t, n.separatorID, parentID)
+		case e.Separator:
+			if t.menuIndex(parentID, n.separatorID) != i {
+				// separators have no backend move, add them again instead
+				n.removeSeparator(t, parentID)
+				menuItemsLock.Lock()
+				t.insertMenuID(parentID, n.separatorID, i)
+				menuItemsLock.Unlock()
+				currentBackend().addSeparator(t, n.separatorID, parentID)
+			}
+		case n == nil:
+			n = &menuNode{key: e.key(), item: newMenuItem(t, e.Title, e.Tooltip, parent)}
+			n.item.setIndex(i)
+			currentBackend().batchMenuItem(n.item)
+			n.apply(e, true)
+			n.item.OnClick(func() { n.clicked(t) })
+		default:
+			currentBackend().batchMenuItem(n.item)
+			if t.menuIndex(parentID, n.item.id) != i {
+				n.item.MoveTo(i)
+			}
+			n.apply(e, false)
+		}
+		n.entry = e
+		if n.item != nil {
+			n.children = t.reconcileMenu(n.item, n.children, e.Items)
+		}
+		nodes[i] = n
+	}
+	return nodes
+}
+
+// apply updates the item of the node to match e, calling the backend only for what changed
+// unless the item was just created
+func (n *menuNode) apply(e MenuEntry, created bool) {
+	item := n.item
+	checkable := e.Checkable || e.RadioGroup != ""
+	if created || item.title != e.Title || item.tooltip != e.Tooltip || item.disabled != e.Disabled ||
+		item.checked != e.Checked || item.isCheckable != checkable || item.radioGroup != e.RadioGroup ||
+		item.shortcutModifiers != e.ShortcutModifiers || item.shortcutKey != e.ShortcutKey {
+		item.title = e.Title
+		item.tooltip = e.Tooltip
+		item.disabled = e.Disabled
+		// the entries are the source of truth, radio siblings are not unchecked here
+		item.checked = e.Checked
+		item.isCheckable = checkable
+		item.radioGroup = e.RadioGroup
+		item.shortcutModifiers = e.ShortcutModifiers
+		item.shortcutKey = e.ShortcutKey
+		item.update()
+	}
+	if !bytes.Equal(e.Icon, n.entry.Icon) {
+		if len(e.Icon) > 0 {
+			item.SetIcon(e.Icon)
+		} else {
+			currentBackend().clearMenuItemIcon(item)
+		}
+	}
+	if e.Hidden != item.hidden {
+		if e.Hidden {
+			item.Hide()
+		} else {
+			item.Show()
+		}
+	}
+}
+
+func (n *menuNode) removeSeparator(t *Tray, parentID uint32) {
+	menuItemsLock.Lock()
+	t.removeMenuID(parentID, n.separatorID)
+	menuItemsLock.Unlock()
+	currentBackend().removeSeparator(t, n.separatorID, parentID)
+}
+
+// clicked calls the OnClick function of the current entry of the node, shown by t
+func (n *menuNode) clicked(t *Tray) {
+	t.declaredMenuLock.Lock()
+	fn := n.entry.OnClick
+	t.declaredMenuLock.Unlock()
+	if fn != nil {
+		fn()
+	}
+}
@@ -4,8 +4,8 @@ package systray
 
 import (
 	"fmt"
-	"log"
 	"os"
+	"sort"
 
 	"github.com/godbus/dbus/v5"
 	"github.com/godbus/dbus/v5/prop"
@@ -16,15 +16,59 @@ import (
 // SetIcon sets the icon of a menu item.
 // iconBytes should be the content of .ico/.jpg/.png
 func (item *MenuItem) SetIcon(iconBytes []byte) {
-	instance.menuLock.Lock()
-	defer instance.menuLock.Unlock()
-	m, exists := findLayout(int32(item.id))
+	logFailure("failed to set menu item icon", item.TrySetIcon(iconBytes), "item", item.id)
+}
+
+// TrySetIcon is like SetIcon but returns an error on failure.
+func (item *MenuItem) TrySetIcon(iconBytes []byte) error {
+	return currentBackend().setMenuItemIcon(item, iconBytes)
+}
+
+func (nativeBackend) setMenuItemIcon(item *MenuItem, iconBytes []byte) error {
+	if err := checkIcon(iconBytes); err != nil {
+		return err
+	}
+	t := item.tray.native
+
+	t.menuLock.Lock()
+	defer t.menuLock.Unlock()
+	m, exists := t.findLayout(int32(item.id))
 	if exists {
 		m.V1["icon-data"] = dbus.MakeVariant(iconBytes)
-		emitItemPropertiesUpdated(int32(item.id), m.V1)
-		// Property-only change; per spec LayoutUpdated isn't required here,
-		// but kept as a fallback for clients that only watch LayoutUpdated.
-		refresh()
+		t.emitItemPropertiesUpdated(int32(item.id), m.V1)
+		t.refreshProperties(int32(item.id))
+	}
+	return nil
+}
+
+// SetIconName sets the icon of a menu item by name from the desktop icon theme,
+// only available on Linux.
+func (item *MenuItem) SetIconName(name string) {
+	currentBackend().setMenuItemIconName(item, name)
+}
+
+func (nativeBackend) setMenuItemIconName(item *MenuItem, name string) {
+	t := item.tray.native
+	t.menuLock.Lock()
+	defer t.menuLock.Unlock()
+	m, exists := t.findLayout(int32(item.id))
+	if exists {
+		m.V1["icon-name"] = dbus.MakeVariant(name)
+		t.emitItemPropertiesUpdated(int32(item.id), m.V1)
+		t.refreshProperties(int32(item.id))
+	}
+}
+
+func (nativeBackend) clearMenuItemIcon(item *MenuItem) {
+	t := item.tray.native
+
+	t.menuLock.Lock()
+	defer t.menuLock.Unlock()
+	m, exists := t.findLayout(int32(item.id))
+	if exists {
+		delete(m.V1, "icon-data")
+		t.emitItemPropertiesUpdated(int32(item.id), m.V1, "icon-data")
+		t.refreshProperties(int32(item.id))
 	}
 }
 
@@ -61,26 +105,26 @@ func copyLayout(in *menuLayout, depth int32) *menuLayout {
 }
 
 // GetLayout is com.canonical.dbusmenu.GetLayout method.
-func (t *tray) GetLayout(parentID int32, recursionDepth int32, propertyNames []string) (revision uint32, layout menuLayout, err *dbus.Error) {
+func (t *nativeTray) GetLayout(parentID int32, recursionDepth int32, propertyNames []string) (revision uint32, layout menuLayout, err *dbus.Error) {
 	initialMenuBuilt.Wait()
-	instance.menuLock.Lock()
-	defer instance.menuLock.Unlock()
-	if m, ok := findLayout(parentID); ok {
+	t.menuLock.Lock()
+	defer t.menuLock.Unlock()
+	if m, ok := t.findLayout(parentID); ok {
 		// return copy of menu layout to prevent panic from cuncurrent access to layout
-		return instance.menuVersion, *copyLayout(m, recursionDepth), nil
+		return t.menuVersion, *copyLayout(m, recursionDepth), nil
 	}
 	return
 }
 
 // GetGroupProperties is com.canonical.dbusmenu.GetGroupProperties method.
-func (t *tray) GetGroupProperties(ids []int32, propertyNames []string) (properties []struct {
+func (t *nativeTray) GetGroupProperties(ids []int32, propertyNames []string) (properties []struct {
 	V0 int32
 	V1 map[string]dbus.Variant
 }, err *dbus.Error) {
-	instance.menuLock.Lock()
-	defer instance.menuLock.Unlock()
+	t.menuLock.Lock()
+	defer t.menuLock.Unlock()
 	for _, id := range ids {
-		if m, ok := findLayout(id); ok {
+		if m, ok := t.findLayout(id); ok {
 			p := struct {
 				V0 int32
 				V1 map[string]dbus.Variant
@@ -98,10 +142,10 @@ func (t *tray) GetGroupProperties(ids []int32, propertyNames []string) (properti
 }
 
 // GetProperty is com.canonical.dbusmenu.GetProperty method.
-func (t *tray) GetProperty(id int32, name string) (value dbus.Variant, err *dbus.Error) {
-	instance.menuLock.Lock()
-	defer instance.menuLock.Unlock()
-	if m, ok := findLayout(id); ok {
+func (t *nativeTray) GetProperty(id int32, name string) (value dbus.Variant, err *dbus.Error) {
+	t.menuLock.Lock()
+	defer t.menuLock.Unlock()
+	if m, ok := t.findLayout(id); ok {
 		if p, ok := m.V1[name]; ok {
 			return p, nil
 		}
@@ -110,57 +154,73 @@ func (t *tray) GetProperty(id int32, name string) (value dbus.Variant, err *dbus
 }
 
 // Event is com.canonical.dbusmenu.Event method.
-func (t *tray) Event(id int32, eventID string, data dbus.Variant, timestamp uint32) (err *dbus.Error) {
-	switch eventID {
-	case "clicked":
-		systrayMenuItemSelected(uint32(id))
-	case "opened":
-		t.menuLock.RLock()
-		rootMenuID := t.menu.V0
-		t.menuLock.RUnlock()
-
-		if id == rootMenuID {
-			select {
-			case TrayOpenedCh <- struct{}{}:
-			default:
-			}
-		}
-	}
+func (t *nativeTray) Event(id int32, eventID string, data dbus.Variant, timestamp uint32) (err *dbus.Error) {
+	t.handleEvent(id, eventID, timestamp)
 	return
 }
 
 // EventGroup is com.canonical.dbusmenu.EventGroup method.
-func (t *tray) EventGroup(events []struct {
+func (t *nativeTray) EventGroup(events []struct {
 	V0 int32
 	V1 string
 	V2 dbus.Variant
 	V3 uint32
 }) (idErrors []int32, err *dbus.Error) {
 	for _, event := range events {
-		if event.V1 == "clicked" {
-			systrayMenuItemSelected(uint32(event.V0))
-		}
+		t.handleEvent(event.V0, event.V1, event.V3)
 	}
 	return
 }
 
+// handleEvent dispatches a dbusmenu event received through Event or EventGroup
+func (t *nativeTray) handleEvent(id int32, eventID string, timestamp uint32) {
+	t.menuLock.RLock()
+	if id == t.menu.V0 {
+		id = 0
+	}
+	t.menuLock.RUnlock()
+
+	event := MenuEvent{Timestamp: timestamp}
+	switch eventID {
+	case "clicked":
+		event.Kind = MenuEventClicked
+	case "hovered":
+		event.Kind = MenuEventHovered
+	case "opened":
+		event.Kind = MenuEventOpened
+	case "closed":
+		event.Kind = MenuEventClosed
+	default:
+		return
+	}
+	systrayMenuEvent(t.owner, uint32(id), event)
+}
+
 // AboutToShow is com.canonical.dbusmenu.AboutToShow method.
-func (t *tray) AboutToShow(id int32) (needUpdate bool, err *dbus.Error) {
-	return
+func (t *nativeTray) AboutToShow(id int32) (needUpdate bool, err *dbus.Error) {
+	return systrayMenuAboutToShow(uint32(id)), nil
 }
 
 // AboutToShowGroup is com.canonical.dbusmenu.AboutToShowGroup method.
-func (t *tray) AboutToShowGroup(ids []int32) (updatesNeeded []int32, idErrors []int32, err *dbus.Error) {
+func (t *nativeTray) AboutToShowGroup(ids []int32) (updatesNeeded []int32, idErrors []int32, err *dbus.Error) {
+	for _, id := range ids {
+		if systrayMenuAboutToShow(uint32(id)) {
+			updatesNeeded = append(updatesNeeded, id)
+		}
+	}
 	return
 }
 
-func createMenuPropSpec() map[string]map[string]*prop.Prop {
-	instance.menuLock.Lock()
-	defer instance.menuLock.Unlock()
+func (t *nativeTray) createMenuPropSpec() map[string]map[string]*prop.Prop {
+	t.lock.Lock()
+	themePath := t.iconThemePath
+	t.lock.Unlock()
+	t.menuLock.Lock()
+	defer t.menuLock.Unlock()
 	return map[string]map[string]*prop.Prop{
 		"com.canonical.dbusmenu": {
 			"Version": {
-				Value:    instance.menuVersion,
+				Value:    t.menuVersion,
 				Writable: true,
 				Emit:     prop.EmitTrue,
 				Callback: nil,
@@ -178,8 +238,8 @@ func createMenuPropSpec() map[string]map[string]*prop.Prop {
 				Callback: nil,
 			},
 			"IconThemePath": {
-				Value:    []string{},
-				Writable: false,
+				Value:    iconThemePaths(themePath),
+				Writable: true,
 				Emit:     prop.EmitTrue,
 				Callback: nil,
 			},
@@ -187,6 +247,14 @@ func createMenuPropSpec() map[string]map[string]*prop.Prop {
 	}
 }
 
+// iconThemePaths returns the value of the menu IconThemePath property for dir.
+func iconThemePaths(dir string) []string {
+	if dir == "" {
+		return []string{}
+	}
+	return []string{dir}
+}
+
 // menuLayout is a named struct to map into generated bindings. It represents the layout of a menu item
 type menuLayout = struct {
 	V0 int32                   // the unique ID of this item
@@ -195,11 +263,12 @@ type menuLayout = struct {
 }
 
 func addOrUpdateMenuItem(item *MenuItem) {
+	t := item.tray.native
 	var layout *menuLayout
 	var parentForChildrenDisplayUpdate *menuLayout
-	instance.menuLock.Lock()
-	defer instance.menuLock.Unlock()
-	m, exists := findLayout(int32(item.id))
+	t.menuLock.Lock()
+	defer t.menuLock.Unlock()
+	m, exists := t.findLayout(int32(item.id))
 	if exists {
 		layout = m
 	} else {
@@ -209,9 +278,9 @@ func addOrUpdateMenuItem(item *MenuItem) {
 			V2: []dbus.Variant{},
 		}
 
-		parent := instance.menu
+		parent := t.menu
 		if item.parent != nil {
-			m, ok := findLayout(int32(item.parent.id))
+			m, ok := t.findLayout(int32(item.parent.id))
 			if ok {
 				parent = m
 				if _, already := parent.V1["children-display"]; !already {
@@ -222,31 +291,30 @@ func addOrUpdateMenuItem(item *MenuItem) {
 				}
 			}
 		}
-		parent.V2 = append(parent.V2, dbus.MakeVariant(layout))
+		parent.V2 = insertLayout(parent.V2, layout, item.tray.menuIndex(item.parentId(), item.id))
 	}
 
 	applyItemToLayout(item, layout)
 	if exists {
-		emitItemPropertiesUpdated(int32(item.id), layout.V1)
-		// Property-only change on an existing item; per spec LayoutUpdated isn't required here,
-		// but kept as a fallback for clients that only watch LayoutUpdated.
-		refresh()
+		t.emitItemPropertiesUpdated(int32(item.id), layout.V1)
+		t.refreshProperties(int32(item.id))
 	} else {
 		// We've added "children-display", that's a property change
 		if parentForChildrenDisplayUpdate != nil {
-			emitItemPropertiesUpdated(parentForChildrenDisplayUpdate.V0, parentForChildrenDisplayUpdate.V1)
+			t.emitItemPropertiesUpdated(parentForChildrenDisplayUpdate.V0, parentForChildrenDisplayUpdate.V1)
 		}
 		// New item appended to a parent's children,
 		// that's a structural change, so LayoutUpdated signal is required
-		refresh()
+		t.refresh()
 	}
 }
 
-func addSeparator(id uint32, parent uint32) {
-	menu, _ := findLayout(int32(parent))
+func (t *Tray) addSeparator(id uint32, parent uint32) {
+	n := t.native
+	menu, _ := n.findLayout(int32(parent))
 
-	instance.menuLock.Lock()
-	defer instance.menuLock.Unlock()
+	n.menuLock.Lock()
+	defer n.menuLock.Unlock()
 	layout := &menuLayout{
 		V0: int32(id),
 		V1: map[string]dbus.Variant{
@@ -254,16 +322,47 @@ func addSeparator(id uint32, parent uint32) {
 		},
 		V2: []dbus.Variant{},
 	}
-	menu.V2 = append(menu.V2, dbus.MakeVariant(layout))
-	refresh()
+	menu.V2 = insertLayout(menu.V2, layout, t.menuIndex(parent, id))
+	n.refresh()
+}
+
+func (t *Tray) removeSeparator(id uint32, parent uint32) {
+	n := t.native
+	n.menuLock.Lock()
+	defer n.menuLock.Unlock()
+
+	menu, ok := n.findLayout(int32(parent))
+	if !ok {
+		return
+	}
+	if items, removed := removeSubLayout(int32(id), menu.V2); removed {
+		menu.V2 = items
+		n.refresh()
+	}
 }
 
 func applyItemToLayout(in *MenuItem, out *menuLayout) {
+	// radio groups are switched under the lock
+	menuItemsLock.RLock()
+	defer menuItemsLock.RUnlock()
 	out.V1["enabled"] = dbus.MakeVariant(!in.disabled)
 	out.V1["label"] = dbus.MakeVariant(in.title)
+	// there's no standard tooltip property, hosts read one of these
+	out.V1["tooltip"] = dbus.MakeVariant(in.tooltip)
+	out.V1["accessible-desc"] = dbus.MakeVariant(in.tooltip)
+
+	out.V1["shortcut"] = dbus.MakeVariant(shortcutFor(in.shortcutModifiers, in.shortcutKey))
+	if in.aboutToShow != nil {
+		// shown as a sub-menu before it is populated
+		out.V1["children-display"] = dbus.MakeVariant("submenu")
+	}
 
 	if in.isCheckable {
-		out.V1["toggle-type"] = dbus.MakeVariant("checkmark")
+		if in.radioGroup != "" {
+			out.V1["toggle-type"] = dbus.MakeVariant("radio")
+		} else {
+			out.V1["toggle-type"] = dbus.MakeVariant("checkmark")
+		}
 		if in.checked {
 			out.V1["toggle-state"] = dbus.MakeVariant(1)
 		} else {
@@ -275,11 +374,34 @@ func applyItemToLayout(in *MenuItem, out *menuLayout) {
 	}
 }
 
-func findLayout(id int32) (*menuLayout, bool) {
+// shortcutFor returns the dbusmenu shortcut property value, a list of key combinations
+// each made of modifier names followed by the key.
+func shortcutFor(modifiers Modifier, key string) [][]string {
+	if key == "" {
+		return [][]string{}
+	}
+
+	var keys []string
+	if modifiers&ModifierControl != 0 {
+		keys = append(keys, "Control")
+	}
+	if modifiers&ModifierAlt != 0 {
+		keys = append(keys, "Alt")
+	}
+	if modifiers&ModifierShift != 0 {
+		keys = append(keys, "Shift")
+	}
+	if modifiers&ModifierSuper != 0 {
+		keys = append(keys, "Super")
+	}
+	return [][]string{append(keys, key)}
+}
+
+func (t *nativeTray) findLayout(id int32) (*menuLayout, bool) {
 	if id == 0 {
-		return instance.menu, true
+		return t.menu, true
 	}
-	return findSubLayout(id, instance.menu.V2)
+	return findSubLayout(id, t.menu.V2)
 }
 
 func findSubLayout(id int32, vals []dbus.Variant) (*menuLayout, bool) {
@@ -317,13 +439,51 @@ func removeSubLayout(id int32, vals []dbus.Variant) ([]dbus.Variant, bool) {
 	return vals, false
 }
 
+// insertLayout inserts layout in vals at index, appending it if index is out of range.
+func insertLayout(vals []dbus.Variant, layout *menuLayout, index int) []dbus.Variant {
+	if index < 0 || index >= len(vals) {
+		return append(vals, dbus.MakeVariant(layout))
+	}
+	vals = append(vals, dbus.Variant{})
+	copy(vals[index+1:], vals[index:])
+	vals[index] = dbus.MakeVariant(layout)
+	return vals
+}
+
+func moveMenuItem(item *MenuItem) {
+	t := item.tray.native
+	t.menuLock.Lock()
+	defer t.menuLock.Unlock()
+
+	parent := t.menu
+	if item.parent != nil {
+		m, ok := t.findLayout(int32(item.parent.id))
+		if !ok {
+			return
+		}
+		parent = m
+	}
+
+	for i, v := range parent.V2 {
+		layout := v.Value().(*menuLayout)
+		if layout.V0 == int32(item.id) {
+			items := append(parent.V2[:i], parent.V2[i+1:]...)
+			parent.V2 = insertLayout(items, layout, item.tray.menuIndex(item.parentId(), item.id))
+			// Reordering children is a structural change, so LayoutUpdated signal is required
+			t.refresh()
+			return
+		}
+	}
+}
+
 func removeMenuItem(item *MenuItem) {
-	instance.menuLock.Lock()
-	defer instance.menuLock.Unlock()
+	t := item.tray.native
+	t.menuLock.Lock()
+	defer t.menuLock.Unlock()
 
-	parent := instance.menu
+	parent := t.menu
 	if item.parent != nil {
-		m, ok := findLayout(int32(item.parent.id))
+		m, ok := t.findLayout(int32(item.parent.id))
 		if !ok {
 			return
 		}
@@ -332,89 +492,242 @@ func removeMenuItem(item *MenuItem) {
 
 	if items, removed := removeSubLayout(int32(item.id), parent.V2); removed {
 		parent.V2 = items
-		refresh()
+		t.refresh()
 	}
 }
 
 func hideMenuItem(item *MenuItem) {
-	instance.menuLock.Lock()
-	defer instance.menuLock.Unlock()
-	m, exists := findLayout(int32(item.id))
+	t := item.tray.native
+	t.menuLock.Lock()
+	defer t.menuLock.Unlock()
+	m, exists := t.findLayout(int32(item.id))
 	if exists {
 		m.V1["visible"] = dbus.MakeVariant(false)
-		emitItemPropertiesUpdated(int32(item.id), m.V1)
-		// Property-only change; per spec LayoutUpdated isn't required here,
-		// but kept as a fallback for clients that only watch LayoutUpdated.
-		refresh()
+		t.emitItemPropertiesUpdated(int32(item.id), m.V1)
+		t.refreshProperties(int32(item.id))
 	}
 }
 
 func showMenuItem(item *MenuItem) {
-	instance.menuLock.Lock()
-	defer instance.menuLock.Unlock()
-	m, exists := findLayout(int32(item.id))
+	t := item.tray.native
+	t.menuLock.Lock()
+	defer t.menuLock.Unlock()
+	m, exists := t.findLayout(int32(item.id))
 	if exists {
 		m.V1["visible"] = dbus.MakeVariant(true)
-		emitItemPropertiesUpdated(int32(item.id), m.V1)
-		// Property-only change; per spec LayoutUpdated isn't required here,
-		// but kept as a fallback for clients that only watch LayoutUpdated.
-		refresh()
+		t.emitItemPropertiesUpdated(int32(item.id), m.V1)
+		t.refreshProperties(int32(item.id))
 	}
 }
 
 // emitItemPropertiesUpdated emits the com.canonical.dbusmenu.ItemsPropertiesUpdated
 // signal so desktop clients refresh per-item state (label, enabled, toggle-state,
 // visible, icon-data) without re-querying the whole layout.
-func emitItemPropertiesUpdated(id int32, props map[string]dbus.Variant) {
-	instance.lock.Lock()
-	conn := instance.conn
-	instance.lock.Unlock()
+// During a batch, the properties are collected until it ends. menuLock must be held.
+func (t *nativeTray) emitItemPropertiesUpdated(id int32, props map[string]dbus.Variant, removed ...string) {
+	if t.batch != nil {
+		t.batch.propertiesUpdated(id, props, removed)
+		return
+	}
+	body := &menu.Dbusmenu_ItemsPropertiesUpdatedSignalBody{
+		UpdatedProps: []struct {
+			V0 int32
+			V1 map[string]dbus.Variant
+		}{{V0: id, V1: props}},
+		RemovedProps: []struct {
+			V0 int32
+			V1 []string
+		}{},
+	}
+	if len(removed) > 0 {
+		body.RemovedProps = append(body.RemovedProps, struct {
+			V0 int32
+			V1 []string
+		}{V0: id, V1: removed})
+	}
+	t.emitPropertiesUpdated(body)
+}
+
+func (t *nativeTray) emitPropertiesUpdated(body *menu.Dbusmenu_ItemsPropertiesUpdatedSignalBody) {
+	t.lock.Lock()
+	conn := t.conn
+	t.lock.Unlock()
 	if conn == nil {
 		return
 	}
 	err := menu.Emit(conn, &menu.Dbusmenu_ItemsPropertiesUpdatedSignal{
-		Path: menuPath,
-		Body: &menu.Dbusmenu_ItemsPropertiesUpdatedSignalBody{
-			UpdatedProps: []struct {
-				V0 int32
-				V1 map[string]dbus.Variant
-			}{{V0: id, V1: props}},
-		},
+		Path: t.menuPath(),
+		Body: body,
 	})
 	if err != nil {
-		log.Printf("systray error: failed to emit items properties updated signal: %v\n", err)
+		logError("failed to emit items properties updated signal", "signal", "ItemsPropertiesUpdated", "error", err)
+	}
+}
+
+// refreshProperties follows a property-only change of the item with the ID. Per spec
+// LayoutUpdated isn't required, but it is kept as a fallback for clients that only watch
+// LayoutUpdated, except for the items reconciled by SetMenu as it tells structural changes
+// apart. menuLock must be held.
+func (t *nativeTray) refreshProperties(id int32) {
+	if t.batch != nil && t.batch.reconciled[id] {
+		return
 	}
+	t.refresh()
 }
 
-func refresh() {
-	instance.lock.Lock()
-	defer instance.lock.Unlock()
-	if instance.conn == nil || instance.menuProps == nil {
+// refresh emits LayoutUpdated, once the batch ends during one. menuLock must be held.
+func (t *nativeTray) refresh() {
+	if t.batch != nil {
+		t.batch.layoutUpdated = true
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.conn == nil || t.menuProps == nil {
 		return
 	}
-	instance.menuVersion++
-	dbusErr := instance.menuProps.Set("com.canonical.dbusmenu", "Version",
-		dbus.MakeVariant(instance.menuVersion))
+	t.menuVersion++
+	dbusErr := t.menuProps.Set("com.canonical.dbusmenu", "Version",
+		dbus.MakeVariant(t.menuVersion))
 	if dbusErr != nil {
-		log.Printf("systray error: failed to update menu version: %v\n", dbusErr)
+		logError("failed to update menu version", "property", "Version", "error", dbusErr)
 		return
 	}
-	err := menu.Emit(instance.conn, &menu.Dbusmenu_LayoutUpdatedSignal{
-		Path: menuPath,
+	err := menu.Emit(t.conn, &menu.Dbusmenu_LayoutUpdatedSignal{
+		Path: t.menuPath(),
 		Body: &menu.Dbusmenu_LayoutUpdatedSignalBody{
-			Revision: instance.menuVersion,
+			Revision: t.menuVersion,
 		},
 	})
 	if err != nil {
-		log.Printf("systray error: failed to emit layout updated signal: %v\n", err)
+		logError("failed to emit layout updated signal", "signal", "LayoutUpdated", "error", err)
 	}
 
 }
 
-func resetMenu() {
-	instance.menuLock.Lock()
-	defer instance.menuLock.Unlock()
-	instance.menu = &menuLayout{}
-	instance.menuVersion++
-	refresh()
+func (t *Tray) resetMenu() {
+	n := t.native
+	n.menuLock.Lock()
+	defer n.menuLock.Unlock()
+	n.menu = &menuLayout{}
+	n.menuVersion++
+	n.refresh()
+}
+
+// menuBatch collects the signals of the changes made to a menu while SetMenu reconciles it,
+// or a radio group is switched, so that they are emitted once it is done
+type menuBatch struct {
+	// depth counts the batches begun and not ended yet, they share the signals
+	depth int
+	// reconciled holds the IDs of the items reconciled by SetMenu, their property changes
+	// are only signalled with ItemsPropertiesUpdated
+	reconciled    map[int32]bool
+	updated       map[int32]map[string]dbus.Variant
+	removed       map[int32][]string
+	layoutUpdated bool
+}
+
+// propertiesUpdated merges the properties of the item with the ID in the batch, props being
+// all of its properties
+func (b *menuBatch) propertiesUpdated(id int32, props map[string]dbus.Variant, removed []string) {
+	updated := make(map[string]dbus.Variant, len(props))
+	for k, v := range props {
+		updated[k] = v
+	}
+	b.updated[id] = updated
+	for _, k := range removed {
+		b.removed[id] = append(b.removed[id], k)
+	}
+}
+
+func (nativeBackend) beginMenuBatch(t *Tray) {
+	n := t.native
+	n.menuLock.Lock()
+	defer n.menuLock.Unlock()
+	if n.batch != nil {
+		n.batch.depth++
+		return
+	}
+	n.batch = &menuBatch{
+		depth:      1,
+		reconciled: make(map[int32]bool),
+		updated:    make(map[int32]map[string]dbus.Variant),
+		removed:    make(map[int32][]string),
+	}
+}
+
+func (nativeBackend) batchMenuItem(item *MenuItem) {
+	n := item.tray.native
+	n.menuLock.Lock()
+	defer n.menuLock.Unlock()
+	if n.batch != nil {
+		n.batch.reconciled[int32(item.id)] = true
+	}
+}
+
+func (nativeBackend) endMenuBatch(t *Tray) {
+	n := t.native
+	n.menuLock.Lock()
+	defer n.menuLock.Unlock()
+	b := n.batch
+	if b == nil {
+		return
+	}
+	if b.depth--; b.depth > 0 {
+		return
+	}
+	n.batch = nil
+
+	body := &menu.Dbusmenu_ItemsPropertiesUpdatedSignalBody{
+		UpdatedProps: []struct {
+			V0 int32
+			V1 map[string]dbus.Variant
+		}{},
+		RemovedProps: []struct {
+			V0 int32
+			V1 []string
+		}{},
+	}
+	for _, id := range sortedIDs(b.updated, b.removed) {
+		if props, ok := b.updated[id]; ok {
+			body.UpdatedProps = append(body.UpdatedProps, struct {
+				V0 int32
+				V1 map[string]dbus.Variant
+			}{V0: id, V1: props})
+		}
+		var removed []string
+		for _, k := range b.removed[id] {
+			// a property set again after its removal is an update
+			if _, set := b.updated[id][k]; !set {
+				removed = append(removed, k)
+			}
+		}
+		if len(removed) > 0 {
+			body.RemovedProps = append(body.RemovedProps, struct {
+				V0 int32
+				V1 []string
+			}{V0: id, V1: removed})
+		}
+	}
+	if len(body.UpdatedProps) > 0 || len(body.RemovedProps) > 0 {
+		n.emitPropertiesUpdated(body)
+	}
+	if b.layoutUpdated {
+		n.refresh()
+	}
+}
+
+// sortedIDs returns the IDs of the updated and removed properties in order
+func sortedIDs(updated map[int32]map[string]dbus.Variant, removed map[int32][]string) []int32 {
+	ids := make([]int32, 0, len(updated)+len(removed))
+	for id := range updated {
+		ids = append(ids, id)
+	}
+	for id := range removed {
+		if _, ok := updated[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
 }
@@ -1,44 +1,55 @@
 package systray
 
 import (
-	"fyne.io/systray/internal/generated/notifier"
+	"strings"
+
 	"github.com/godbus/dbus/v5"
 )
 
+// leftRightNotifierItem handles the clicks on the icon of a tray
 type leftRightNotifierItem struct {
+	tray *Tray
 }
 
-func newLeftRightNotifierItem() notifier.StatusNotifierItemer {
-	return &leftRightNotifierItem{}
-}
-
-func (i *leftRightNotifierItem) Activate(_, _ int32) *dbus.Error {
-	if f := tappedLeft; f == nil {
+func (i *leftRightNotifierItem) Activate(x, y int32) *dbus.Error {
+	f := i.tray.tappedLeftHandler()
+	if f == nil {
 		return &dbus.ErrMsgUnknownMethod
 	}
 
-	tappedLeft()
+	f(int(x), int(y))
 	return nil
 }
 
-func (i *leftRightNotifierItem) ContextMenu(_, _ int32) *dbus.Error {
-	if f := tappedRight; f == nil {
+func (i *leftRightNotifierItem) ContextMenu(x, y int32) *dbus.Error {
+	f := i.tray.tappedRightHandler()
+	if f == nil {
 		return &dbus.ErrMsgUnknownMethod
 	}
 
-	tappedRight()
+	f(int(x), int(y))
 	return nil
 }
 
-func (i *leftRightNotifierItem) SecondaryActivate(_, _ int32) *dbus.Error {
-	if f := tappedRight; f == nil {
-		return &dbus.ErrMsgUnknownMethod
+func (i *leftRightNotifierItem) SecondaryActivate(x, y int32) *dbus.Error {
+	if f := i.tray.tappedMiddleHandler(); f != nil {
+		f(int(x), int(y))
+		return nil
 	}
 
-	tappedRight()
-	return nil
+	return i.ContextMenu(x, y)
 }
 
-func (i *leftRightNotifierItem) Scroll(_ int32, _ string) *dbus.Error {
-	return &dbus.ErrMsgUnknownMethod
+func (i *leftRightNotifierItem) Scroll(delta int32, orientation string) *dbus.Error {
+	f := i.tray.scrollHandler()
+	if f == nil {
+		return &dbus.ErrMsgUnknownMethod
+	}
+
+	o := OrientationVertical
+	if strings.EqualFold(orientation, "horizontal") {
+		o = OrientationHorizontal
+	}
+	f(int(delta), o)
+	return nil
 }
@@ -0,0 +1,421 @@
+package systray
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRadioGroup(t *testing.T) {
+	defer ResetMenu()
+
+	first := AddMenuItemRadio("profile", "First", "")
+	second := AddMenuItemRadio("profile", "Second", "")
+	other := AddMenuItemRadio("other", "Other", "")
+	other.Check()
+
+	first.Check()
+	if !first.Checked() || second.Checked() {
+		t.Fatal("checking an item must leave it the only checked item of its group")
+	}
+
+	systrayMenuItemSelected(second.id)
+	if first.Checked() || !second.Checked() {
+		t.Error("clicking an item must check it and uncheck the rest of its group")
+	}
+	if !other.Checked() {
+		t.Error("items of other groups must not be changed")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() { first.Check(); wg.Done() }()
+		go func() { second.Check(); wg.Done() }()
+	}
+	wg.Wait()
+	menuItemsLock.RLock()
+	checked := first.checked != second.checked
+	menuItemsLock.RUnlock()
+	if !checked {
+		t.Error("concurrent checks must leave a single item of the group checked")
+	}
+}
+
+func TestMenuOrder(t *testing.T) {
+	defer ResetMenu()
+
+	a := AddMenuItem("A", "")
+	b := AddMenuItem("B", "")
+	c := AddMenuItemAt(0, "C", "")
+	assertOrder := func(expected ...*MenuItem) {
+		t.Helper()
+		for i, item := range expected {
+			if index := defaultTray.menuIndex(0, item.id); index != i {
+				t.Errorf("expected %s at %d, got %d", item, i, index)
+			}
+		}
+	}
+	assertOrder(c, a, b)
+
+	b.InsertBefore(c)
+	assertOrder(b, c, a)
+
+	b.MoveTo(2)
+	assertOrder(c, a, b)
+
+	a.InsertBefore(b)
+	assertOrder(c, a, b)
+
+	c.MoveTo(100)
+	assertOrder(a, b, c)
+}
+
+func TestSetMenu(t *testing.T) {
+	defer ResetMenu()
+
+	AddMenuItem("Imperative", "")
+	clicked := make(chan string, 1)
+	SetMenu(Menu{Items: []MenuEntry{
+		{Title: "Open", OnClick: func() { clicked <- "open" }},
+		{Separator: true},
+		{Key: "status", Title: "Stopped", Items: []MenuEntry{{Title: "Start"}}},
+		{Title: "Quit"},
+	}})
+	open := defaultTray.declaredMenu[0].item
+	status := defaultTray.declaredMenu[2].item
+	if len(defaultTray.menuOrder[0]) != 4 {
+		t.Fatalf("expected the imperative item to be replaced, got %d entries", len(defaultTray.menuOrder[0]))
+	}
+
+	SetMenu(Menu{Items: []MenuEntry{
+		{Key: "status", Title: "Running", Items: []MenuEntry{{Title: "Stop"}}},
+		{Separator: true},
+		{Title: "Open", Disabled: true, OnClick: func() { clicked <- "open again" }},
+	}})
+	if defaultTray.declaredMenu[0].item != status || defaultTray.declaredMenu[2].item != open {
+		t.Fatal("items must be matched by key and kept")
+	}
+	if status.title != "Running" || !open.Disabled() {
+		t.Error("matched items must be updated")
+	}
+	for i, id := range []uint32{status.id, defaultTray.declaredMenu[1].separatorID, open.id} {
+		if index := defaultTray.menuIndex(0, id); index != i {
+			t.Errorf("expected entry %d at %d, got %d", id, i, index)
+		}
+	}
+	if len(defaultTray.menuOrder[0]) != 3 || len(defaultTray.menuOrder[status.id]) != 1 {
+		t.Error("entries missing from the menu must be removed")
+	}
+
+	systrayMenuItemSelected(open.id)
+	select {
+	case got := <-clicked:
+		if got != "open again" {
+			t.Errorf("expected the latest OnClick to be called, got %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnClick was not called")
+	}
+}
+
+func TestSetMenuDuplicateKeys(t *testing.T) {
+	defer ResetMenu()
+
+	SetMenu(Menu{Items: []MenuEntry{{Title: "A"}, {Title: "A"}}})
+	first, second := defaultTray.declaredMenu[0].item, defaultTray.declaredMenu[1].item
+	if first == second {
+		t.Fatal("entries sharing a key must have their own items")
+	}
+
+	SetMenu(Menu{Items: []MenuEntry{{Title: "A"}, {Title: "B"}}})
+	if defaultTray.declaredMenu[0].item != first {
+		t.Error("the first entry with a key must keep the first item")
+	}
+	if second.exists() {
+		t.Error("the unmatched item sharing the key must be removed")
+	}
+
+	SetMenu(Menu{Items: []MenuEntry{{Title: "B"}}})
+	if len(defaultTray.menuOrder[0]) != 1 || first.exists() {
+		t.Errorf("expected only B to be left, got %d entries", len(defaultTray.menuOrder[0]))
+	}
+}
+
+func TestRemoveTwice(t *testing.T) {
+	defer ResetMenu()
+
+	item := AddMenuItem("Item", "")
+	child := item.AddSubMenuItem("Child", "")
+	ResetMenu()
+	item.Remove()
+	child.Remove()
+	if item.exists() || child.exists() {
+		t.Error("removed items must stay removed")
+	}
+}
+
+func TestAboutToShow(t *testing.T) {
+	defer ResetMenu()
+
+	recent := AddMenuItem("Recent", "")
+	if systrayMenuAboutToShow(recent.id) {
+		t.Error("no update is needed without a function")
+	}
+
+	recent.SetOnAboutToShow(func() {
+		recent.AddSubMenuItem("Server", "")
+	})
+	if !systrayMenuAboutToShow(recent.id) {
+		t.Error("an update is needed once the function was called")
+	}
+	if len(defaultTray.menuOrder[recent.id]) != 1 {
+		t.Error("expected the sub-menu to be populated")
+	}
+}
+
+func TestMenuOpenedClosed(t *testing.T) {
+	defer ResetMenu()
+
+	servers := AddMenuItem("Servers", "")
+	done := make(chan struct{})
+	go func() {
+		<-servers.OpenedCh
+		<-servers.ClosedCh
+		close(done)
+	}()
+	for {
+		systrayMenuEvent(defaultTray, servers.id, MenuEvent{Kind: MenuEventOpened})
+		systrayMenuEvent(defaultTray, servers.id, MenuEvent{Kind: MenuEventClosed})
+		select {
+		case <-done:
+			return
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestMenuItemEvents(t *testing.T) {
+	defer ResetMenu()
+
+	item := AddMenuItem("Options", "")
+	events := item.Events()
+	systrayMenuEvent(defaultTray, item.id, MenuEvent{Kind: MenuEventHovered, Timestamp: 10})
+	systrayMenuEvent(defaultTray, item.id, MenuEvent{Kind: MenuEventClicked, Timestamp: 12, Modifiers: ModifierAlt})
+
+	if event := <-events; event.Kind != MenuEventHovered || event.Timestamp != 10 {
+		t.Errorf("unexpected first event %+v", event)
+	}
+	if event := <-events; event.Kind != MenuEventClicked || event.Modifiers != ModifierAlt {
+		t.Errorf("unexpected second event %+v", event)
+	}
+
+	item.Remove()
+	if _, ok := <-events; ok {
+		t.Error("expected the channel to be closed once the item is removed")
+	}
+}
+
+func TestClickDelivery(t *testing.T) {
+	defer ResetMenu()
+
+	quit := AddMenuItem("Quit", "")
+	clicks := make(chan int, 3)
+	count := 0
+	quit.OnClick(func() {
+		count++
+		clicks <- count
+	})
+	for i := 0; i < 3; i++ {
+		systrayMenuItemSelected(quit.id)
+	}
+	for i := 1; i <= 3; i++ {
+		select {
+		case got := <-clicks:
+			if got != i {
+				t.Errorf("expected click %d, got %d", i, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("OnClick must be called for every click")
+		}
+	}
+
+	coalesced := AddMenuItem("Refresh", "")
+	ch := coalesced.ClickedCh
+	coalesced.SetClickQueue(0, OverflowDropNewest)
+	if coalesced.ClickedCh != ch {
+		t.Error("SetClickQueue must not replace ClickedCh")
+	}
+	systrayMenuItemSelected(coalesced.id)
+	systrayMenuItemSelected(coalesced.id)
+	if n := coalesced.clicks.len(); n != 1 {
+		t.Errorf("expected clicks to be coalesced, got %d pending", n)
+	}
+	select {
+	case <-coalesced.ClickedCh:
+	case <-time.After(time.Second):
+		t.Fatal("queued click must be delivered")
+	}
+
+	// none of the clicks waits for room on the event loop
+	blocking := AddMenuItem("Save", "")
+	blocking.SetClickQueue(1, OverflowBlock)
+	for i := 0; i < 3; i++ {
+		systrayMenuItemSelected(blocking.id)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case <-blocking.ClickedCh:
+		case <-time.After(time.Second):
+			t.Fatal("blocked clicks must be delivered once there is room")
+		}
+	}
+
+	systrayMenuItemSelected(blocking.id)
+	blocking.Remove()
+	if n := blocking.clicks.len(); n != 0 {
+		t.Errorf("expected Remove to drop the waiting clicks, got %d pending", n)
+	}
+}
+
+func TestHostChanged(t *testing.T) {
+	available := HostAvailable()
+	defer func() {
+		// restored without a handler, so that no change is reported after the test
+		SetOnHostChanged(nil)
+		defaultTray.setHostAvailable(available)
+	}()
+	// start from a known state, once the changes reported to a previous handler are done
+	defaultTray.setHostAvailable(false)
+	callbacks.wait()
+
+	changes := make(chan bool, 3)
+	SetOnHostChanged(func(available bool) {
+		changes <- available
+	})
+	defaultTray.setHostAvailable(false)
+	defaultTray.setHostAvailable(true)
+	defaultTray.setHostAvailable(true)
+	defaultTray.setHostAvailable(false)
+
+	for _, expected := range []bool{true, false} {
+		select {
+		case available := <-changes:
+			if available != expected {
+				t.Errorf("expected a change to %t, got %t", expected, available)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("expected the host change to be reported")
+		}
+	}
+	if HostAvailable() {
+		t.Error("expected the host to be unavailable")
+	}
+}
+
+func TestNewTray(t *testing.T) {
+	defer ResetMenu()
+	tray := NewTray()
+	defer tray.Remove()
+
+	first := AddMenuItemRadio("profile", "First", "")
+	second := tray.AddMenuItemRadio("profile", "Second", "")
+	other := tray.AddMenuItem("Other", "")
+	first.Check()
+	second.Check()
+	if !first.Checked() || !second.Checked() {
+		t.Error("radio groups must not be shared between trays")
+	}
+	if index := tray.menuIndex(0, other.id); index != 1 {
+		t.Errorf("expected the item at 1 in the menu of its tray, got %d", index)
+	}
+	if index := defaultTray.menuIndex(0, other.id); index != -1 {
+		t.Errorf("expected the item to be missing from the default menu, got %d", index)
+	}
+
+	tray.ResetMenu()
+	if second.exists() || !first.exists() {
+		t.Error("resetting the menu of a tray must only remove its items")
+	}
+
+	tray.AddMenuItem("Removed", "")
+	tray.Remove()
+	if len(tray.menuOrder[0]) != 0 {
+		t.Error("removing a tray must remove its menu")
+	}
+	if trays := currentTrays(); len(trays) != 1 || trays[0] != defaultTray {
+		t.Errorf("expected only the default tray to remain, got %d trays", len(trays))
+	}
+}
+
+func TestRunAgain(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("the event loop needs the main thread")
+	}
+	Hide()
+	defer Show()
+
+	for run := 1; run <= 2; run++ {
+		var item *MenuItem
+		exited := 0
+		Run(func() {
+			item = AddMenuItem("Quit", "")
+			Quit()
+			Quit()
+		}, func() {
+			exited++
+		})
+
+		if exited != 1 {
+			t.Errorf("run %d: expected onExit to be called once, got %d", run, exited)
+		}
+		if item.exists() || len(defaultTray.menuOrder[0]) != 0 {
+			t.Errorf("run %d: expected the menu to be reset", run)
+		}
+		if !defaultTray.hidden.Load() {
+			t.Errorf("run %d: expected the tray to stay hidden", run)
+		}
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	if runtime.GOOS == "darwin" {
+		t.Skip("the event loop needs the main thread")
+	}
+	Hide()
+	defer Show()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	exited := false
+	err := RunContext(ctx, WithOnReady(cancel), WithOnExit(func() {
+		exited = true
+	}))
+	if err != nil {
+		t.Errorf("expected no error once cancelled, got %v", err)
+	}
+	if !exited {
+		t.Error("expected onExit to be called")
+	}
+}
+
+func TestReadyCancelled(t *testing.T) {
+	called := false
+	cancel := setCallbacks(func() { called = true }, nil)
+	cancel()
+
+	built := make(chan struct{})
+	go func() {
+		initialMenuBuilt.Wait()
+		close(built)
+	}()
+	select {
+	case <-built:
+	case <-time.After(time.Second):
+		t.Fatal("expected onReady to be released when the tray fails to start")
+	}
+	if called {
+		t.Error("onReady must not be called when the tray fails to start")
+	}
+}
@@ -0,0 +1,534 @@
+package systray
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Tray is an icon in the notification area along with its menu and callbacks.
+// The package level functions act on the default tray shown by Run, NewTray adds more icons.
+type Tray struct {
+	// OpenedCh receives an entry each time the menu of the tray is opened.
+	OpenedCh chan struct{}
+	// ClosedCh receives an entry each time the menu of the tray is closed.
+	ClosedCh chan struct{}
+
+	// id is 1 for the default tray, it tells the native objects of the trays apart
+	id uint32
+
+	// handlers holds the functions set by SetOnTapped and the like, protected by handlersLock
+	handlers     trayHandlers
+	handlersLock sync.RWMutex
+
+	// menuOrder lists the IDs of the items and separators of each menu, keyed by
+	// the ID of the parent item or 0 for the top level menu, in display order.
+	// It is protected by menuItemsLock.
+	menuOrder map[uint32][]uint32
+
+	// declaredMenu holds the entries applied by SetMenu, once menuDeclared
+	declaredMenu     []*menuNode
+	menuDeclared     bool
+	declaredMenuLock sync.Mutex
+
+	// hostAvailable is reported by HostAvailable, hostChanged is protected by hostLock
+	hostAvailable atomic.Bool
+	hostChanged   func(available bool)
+	hostLock      sync.Mutex
+
+	// hidden is set by Hide until Show is called, visibilityLock orders the changes
+	hidden         atomic.Bool
+	visibilityLock sync.Mutex
+
+	// native is the platform specific state of the icon
+	native *nativeTray
+}
+
+// trayHandlers holds the functions handling the clicks and scrolls on the icon of a tray
+type trayHandlers struct {
+	tappedLeft, tappedRight, tappedMiddle       func()
+	tappedLeftAt, tappedRightAt, tappedMiddleAt func(x, y int)
+	scrolled                                    func(delta int, orientation Orientation)
+}
+
+var (
+	// defaultTray is the tray shown by Run and changed by the package level functions
+	defaultTray = newTray(1, TrayOpenedCh, TrayClosedCh)
+
+	// trays holds the trays that have not been removed, keyed by ID
+	trays     = map[uint32]*Tray{1: defaultTray}
+	traysLock sync.Mutex
+	// traysStarted is set once the backend runs, NewTray then creates native icons right away
+	traysStarted bool
+	// addedTrays counts the trays returned by NewTray
+	addedTrays atomic.Uint32
+)
+
+func newTray(id uint32, openedCh, closedCh chan struct{}) *Tray {
+	t := &Tray{
+		OpenedCh:  openedCh,
+		ClosedCh:  closedCh,
+		id:        id,
+		menuOrder: make(map[uint32][]uint32),
+	}
+	t.native = newNativeTray(t)
+	return t
+}
+
+// NewTray adds an icon to the notification area, with its own menu and callbacks.
+// The icon is shown once the default tray is ready, right away if it already is, and stays
+// until it is removed or the application quits.
+func NewTray() *Tray {
+	t := newTray(addedTrays.Add(1)+1, make(chan struct{}), make(chan struct{}))
+	traysLock.Lock()
+	trays[t.id] = t
+	started := traysStarted
+	traysLock.Unlock()
+	if started {
+		logFailure("failed to create tray", t.create(), "tray", t.id)
+	}
+	return t
+}
+
+// Remove removes the icon of the tray along with its menu, the tray should not be used afterwards.
+// The default tray cannot be removed, Quit ends the application instead.
+func (t *Tray) Remove() {
+	if t == defaultTray {
+		logError("cannot remove the default tray")
+		return
+	}
+	traysLock.Lock()
+	_, exists := trays[t.id]
+	delete(trays, t.id)
+	started := traysStarted
+	traysLock.Unlock()
+	if !exists {
+		return
+	}
+
+	t.ResetMenu()
+	if started {
+		logFailure("failed to remove tray", t.destroy(), "tray", t.id)
+	}
+}
+
+// Hide removes the icon of the tray from the notification area until Show is called, the
+// menu and the state of the tray being kept. When called before Run, the icon starts hidden.
+// It can be safely invoked from different goroutines.
+func (t *Tray) Hide() {
+	t.setHidden(true)
+}
+
+// Show brings back the icon of the tray removed by Hide.
+// It can be safely invoked from different goroutines.
+func (t *Tray) Show() {
+	t.setHidden(false)
+}
+
+func (t *Tray) setHidden(hidden bool) {
+	t.visibilityLock.Lock()
+	defer t.visibilityLock.Unlock()
+	if t.hidden.Swap(hidden) == hidden {
+		return
+	}
+
+	traysLock.Lock()
+	_, exists := trays[t.id]
+	started := traysStarted
+	traysLock.Unlock()
+	if !exists || !started {
+		return
+	}
+	if hidden {
+		logFailure("failed to hide tray", t.setVisible(false), "tray", t.id)
+	} else {
+		logFailure("failed to show tray", t.setVisible(true), "tray", t.id)
+	}
+}
+
+// startTrays creates the native icons of the trays added before the backend was running,
+// the default tray being created by the backend itself
+func startTrays() {
+	traysLock.Lock()
+	traysStarted = true
+	traysLock.Unlock()
+	for _, t := range currentTrays() {
+		if t != defaultTray {
+			logFailure("failed to create tray", t.create(), "tray", t.id)
+		} else if t.hidden.Load() {
+			logFailure("failed to hide tray", t.setVisible(false), "tray", t.id)
+		}
+	}
+}
+
+// stopTrays removes the native icons and the menus of all trays once the backend ends,
+// so that it can be started again with empty menus
+func stopTrays() {
+	traysLock.Lock()
+	traysStarted = false
+	traysLock.Unlock()
+	for _, t := range currentTrays() {
+		t.ResetMenu()
+		logFailure("failed to remove tray", t.destroy(), "tray", t.id)
+	}
+}
+
+// currentTrays returns the trays that have not been removed, in the order they were created
+func currentTrays() []*Tray {
+	traysLock.Lock()
+	defer traysLock.Unlock()
+	list := make([]*Tray, 0, len(trays))
+	for _, t := range trays {
+		list = append(list, t)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].id < list[j].id
+	})
+	return list
+}
+
+// SetOnTapped sets a function to be called when the tray icon is clicked with the primary button,
+// instead of showing the menu.
+func (t *Tray) SetOnTapped(f func()) {
+	t.setHandlers(func(h *trayHandlers) { h.tappedLeft = f })
+}
+
+// SetOnSecondaryTapped sets a function to be called when the tray icon is clicked with the
+// secondary button, instead of showing the menu.
+func (t *Tray) SetOnSecondaryTapped(f func()) {
+	t.setHandlers(func(h *trayHandlers) { h.tappedRight = f })
+}
+
+// SetOnTappedAt is like SetOnTapped but the function also receives the screen position of
+// the click, with the origin at the top left, so that a window can be placed next to the icon.
+// It takes precedence over a function set with SetOnTapped.
+func (t *Tray) SetOnTappedAt(f func(x, y int)) {
+	t.setHandlers(func(h *trayHandlers) { h.tappedLeftAt = f })
+}
+
+// SetOnSecondaryTappedAt is like SetOnSecondaryTapped but the function also receives the screen
+// position of the click, with the origin at the top left.
+// It takes precedence over a function set with SetOnSecondaryTapped.
+func (t *Tray) SetOnSecondaryTappedAt(f func(x, y int)) {
+	t.setHandlers(func(h *trayHandlers) { h.tappedRightAt = f })
+}
+
+// SetOnMiddleTapped sets a function to be called when the tray icon is clicked with the middle button.
+// On Linux, middle clicks are handled like secondary taps when it is not set.
+func (t *Tray) SetOnMiddleTapped(f func()) {
+	t.setHandlers(func(h *trayHandlers) { h.tappedMiddle = f })
+}
+
+// SetOnMiddleTappedAt is like SetOnMiddleTapped but the function also receives the screen
+// position of the click, with the origin at the top left.
+// It takes precedence over a function set with SetOnMiddleTapped.
+func (t *Tray) SetOnMiddleTappedAt(f func(x, y int)) {
+	t.setHandlers(func(h *trayHandlers) { h.tappedMiddleAt = f })
+}
+
+// SetOnScroll sets a function to be called when the mouse wheel is used over the tray icon.
+// The delta is positive when scrolling up, a wheel notch usually being 120.
+// On Windows, where the notification area does not forward the mouse wheel to the icons, a
+// low-level mouse hook catches it while the cursor is over the icon.
+func (t *Tray) SetOnScroll(f func(delta int, orientation Orientation)) {
+	t.setHandlers(func(h *trayHandlers) { h.scrolled = f })
+}
+
+// setHandlers applies f to the handlers of the tray
+func (t *Tray) setHandlers(f func(h *trayHandlers)) {
+	t.handlersLock.Lock()
+	f(&t.handlers)
+	t.handlersLock.Unlock()
+}
+
+// currentHandlers returns a copy of the handlers of the tray
+func (t *Tray) currentHandlers() trayHandlers {
+	t.handlersLock.RLock()
+	defer t.handlersLock.RUnlock()
+	return t.handlers
+}
+
+// tappedLeftHandler returns the function handling a primary click, or nil if the menu should be shown.
+func (t *Tray) tappedLeftHandler() func(x, y int) {
+	h := t.currentHandlers()
+	if fn := h.tappedLeftAt; fn != nil {
+		return fn
+	}
+	if fn := h.tappedLeft; fn != nil {
+		return func(int, int) { fn() }
+	}
+	return nil
+}
+
+// tappedRightHandler returns the function handling a secondary click, or nil if the menu should be shown.
+func (t *Tray) tappedRightHandler() func(x, y int) {
+	h := t.currentHandlers()
+	if fn := h.tappedRightAt; fn != nil {
+		return fn
+	}
+	if fn := h.tappedRight; fn != nil {
+		return func(int, int) { fn() }
+	}
+	return nil
+}
+
+// tappedMiddleHandler returns the function handling a middle click, or nil if there is none.
+func (t *Tray) tappedMiddleHandler() func(x, y int) {
+	h := t.currentHandlers()
+	if fn := h.tappedMiddleAt; fn != nil {
+		return fn
+	}
+	if fn := h.tappedMiddle; fn != nil {
+		return func(int, int) { fn() }
+	}
+	return nil
+}
+
+// scrollHandler returns the function handling the mouse wheel, or nil if there is none.
+func (t *Tray) scrollHandler() func(delta int, orientation Orientation) {
+	return t.currentHandlers().scrolled
+}
+
+// SetIcon sets the icon of the default tray, see Tray.SetIcon.
+func SetIcon(iconBytes []byte) {
+	defaultTray.SetIcon(iconBytes)
+}
+
+// TrySetIcon is like SetIcon but returns an error on failure.
+func TrySetIcon(iconBytes []byte) error {
+	return defaultTray.TrySetIcon(iconBytes)
+}
+
+// SetIconSet sets the icon of the default tray from images of different sizes, see Tray.SetIconSet.
+func SetIconSet(icons map[int][]byte) {
+	defaultTray.SetIconSet(icons)
+}
+
+// TrySetIconSet is like SetIconSet but returns an error on failure.
+func TrySetIconSet(icons map[int][]byte) error {
+	return defaultTray.TrySetIconSet(icons)
+}
+
+// SetTemplateIcon sets the icon of the default tray as a template icon, see Tray.SetTemplateIcon.
+func SetTemplateIcon(templateIconBytes []byte, regularIconBytes []byte) {
+	defaultTray.SetTemplateIcon(templateIconBytes, regularIconBytes)
+}
+
+// TrySetTemplateIcon is like SetTemplateIcon but returns an error on failure.
+func TrySetTemplateIcon(templateIconBytes []byte, regularIconBytes []byte) error {
+	return defaultTray.TrySetTemplateIcon(templateIconBytes, regularIconBytes)
+}
+
+// SetAttentionIcon sets the icon shown by the default tray while the status is
+// StatusNeedsAttention, see Tray.SetAttentionIcon.
+func SetAttentionIcon(iconBytes []byte) {
+	defaultTray.SetAttentionIcon(iconBytes)
+}
+
+// TrySetAttentionIcon is like SetAttentionIcon but returns an error on failure.
+func TrySetAttentionIcon(iconBytes []byte) error {
+	return defaultTray.TrySetAttentionIcon(iconBytes)
+}
+
+// SetOverlayIcon sets a small icon drawn over the icon of the default tray, see Tray.SetOverlayIcon.
+func SetOverlayIcon(iconBytes []byte) {
+	defaultTray.SetOverlayIcon(iconBytes)
+}
+
+// TrySetOverlayIcon is like SetOverlayIcon but returns an error on failure.
+// Empty iconBytes remove the overlay icon.
+func TrySetOverlayIcon(iconBytes []byte) error {
+	return defaultTray.TrySetOverlayIcon(iconBytes)
+}
+
+// ClearOverlayIcon removes the icon set by SetOverlayIcon.
+func ClearOverlayIcon() {
+	defaultTray.ClearOverlayIcon()
+}
+
+// SetIconName sets the icon of the default tray by name from the desktop icon theme,
+// see Tray.SetIconName.
+func SetIconName(name string) {
+	defaultTray.SetIconName(name)
+}
+
+// TrySetIconName is like SetIconName but returns an error on failure.
+func TrySetIconName(name string) error {
+	return defaultTray.TrySetIconName(name)
+}
+
+// SetAttentionIconName sets the icon shown by the default tray while the status is
+// StatusNeedsAttention by name from the desktop icon theme, see Tray.SetAttentionIconName.
+func SetAttentionIconName(name string) {
+	defaultTray.SetAttentionIconName(name)
+}
+
+// TrySetAttentionIconName is like SetAttentionIconName but returns an error on failure.
+func TrySetAttentionIconName(name string) error {
+	return defaultTray.TrySetAttentionIconName(name)
+}
+
+// SetOverlayIconName sets the icon drawn over the icon of the default tray by name from the
+// desktop icon theme, see Tray.SetOverlayIconName.
+func SetOverlayIconName(name string) {
+	defaultTray.SetOverlayIconName(name)
+}
+
+// TrySetOverlayIconName is like SetOverlayIconName but returns an error on failure.
+func TrySetOverlayIconName(name string) error {
+	return defaultTray.TrySetOverlayIconName(name)
+}
+
+// SetIconThemePath adds a directory to the icon theme search path of the default tray,
+// see Tray.SetIconThemePath.
+func SetIconThemePath(dir string) {
+	defaultTray.SetIconThemePath(dir)
+}
+
+// TrySetIconThemePath is like SetIconThemePath but returns an error on failure.
+func TrySetIconThemePath(dir string) error {
+	return defaultTray.TrySetIconThemePath(dir)
+}
+
+// SetIconFromFilePath sets the icon of the default tray from a file path, see Tray.SetIconFromFilePath.
+func SetIconFromFilePath(iconFilePath string) error {
+	return defaultTray.SetIconFromFilePath(iconFilePath)
+}
+
+// SetTitle sets the title of the default tray, see Tray.SetTitle.
+func SetTitle(title string) {
+	defaultTray.SetTitle(title)
+}
+
+// TrySetTitle is like SetTitle but returns an error on failure.
+func TrySetTitle(title string) error {
+	return defaultTray.TrySetTitle(title)
+}
+
+// SetTooltip sets the tooltip of the default tray, see Tray.SetTooltip.
+func SetTooltip(tooltip string) {
+	defaultTray.SetTooltip(tooltip)
+}
+
+// TrySetTooltip is like SetTooltip but returns an error on failure.
+func TrySetTooltip(tooltip string) error {
+	return defaultTray.TrySetTooltip(tooltip)
+}
+
+// SetStatus sets the status of the default tray, see Tray.SetStatus.
+func SetStatus(status Status) {
+	defaultTray.SetStatus(status)
+}
+
+// TrySetStatus is like SetStatus but returns an error on failure.
+func TrySetStatus(status Status) error {
+	return defaultTray.TrySetStatus(status)
+}
+
+// SetRemovalAllowed sets whether a user can remove the icon of the default tray,
+// see Tray.SetRemovalAllowed.
+func SetRemovalAllowed(allowed bool) {
+	defaultTray.SetRemovalAllowed(allowed)
+}
+
+// Hide removes the icon of the default tray from the notification area until Show is called,
+// see Tray.Hide.
+func Hide() {
+	defaultTray.Hide()
+}
+
+// Show brings back the icon of the default tray removed by Hide.
+func Show() {
+	defaultTray.Show()
+}
+
+// HostAvailable returns whether the icon of the default tray can currently be displayed,
+// see Tray.HostAvailable.
+func HostAvailable() bool {
+	return defaultTray.HostAvailable()
+}
+
+// SetOnHostChanged sets a function to be called each time HostAvailable changes, with its new
+// value, see Tray.SetOnHostChanged.
+func SetOnHostChanged(f func(available bool)) {
+	defaultTray.SetOnHostChanged(f)
+}
+
+// SetOnTapped sets a function to be called when the icon of the default tray is clicked,
+// see Tray.SetOnTapped.
+func SetOnTapped(f func()) {
+	defaultTray.SetOnTapped(f)
+}
+
+// SetOnSecondaryTapped sets a function to be called when the icon of the default tray is
+// clicked with the secondary button, see Tray.SetOnSecondaryTapped.
+func SetOnSecondaryTapped(f func()) {
+	defaultTray.SetOnSecondaryTapped(f)
+}
+
+// SetOnTappedAt is like SetOnTapped but the function also receives the screen position of
+// the click, see Tray.SetOnTappedAt.
+func SetOnTappedAt(f func(x, y int)) {
+	defaultTray.SetOnTappedAt(f)
+}
+
+// SetOnSecondaryTappedAt is like SetOnSecondaryTapped but the function also receives the screen
+// position of the click, see Tray.SetOnSecondaryTappedAt.
+func SetOnSecondaryTappedAt(f func(x, y int)) {
+	defaultTray.SetOnSecondaryTappedAt(f)
+}
+
+// SetOnMiddleTapped sets a function to be called when the icon of the default tray is clicked
+// with the middle button, see Tray.SetOnMiddleTapped.
+func SetOnMiddleTapped(f func()) {
+	defaultTray.SetOnMiddleTapped(f)
+}
+
+// SetOnMiddleTappedAt is like SetOnMiddleTapped but the function also receives the screen
+// position of the click, see Tray.SetOnMiddleTappedAt.
+func SetOnMiddleTappedAt(f func(x, y int)) {
+	defaultTray.SetOnMiddleTappedAt(f)
+}
+
+// SetOnScroll sets a function to be called when the mouse wheel is used over the icon of the
+// default tray, see Tray.SetOnScroll.
+func SetOnScroll(f func(delta int, orientation Orientation)) {
+	defaultTray.SetOnScroll(f)
+}
+
+// AddMenuItem adds a menu item to the menu of the default tray, see Tray.AddMenuItem.
+func AddMenuItem(title string, tooltip string) *MenuItem {
+	return defaultTray.AddMenuItem(title, tooltip)
+}
+
+// AddMenuItemCheckbox adds a menu item with a checkbox to the menu of the default tray,
+// see Tray.AddMenuItemCheckbox.
+func AddMenuItemCheckbox(title string, tooltip string, checked bool) *MenuItem {
+	return defaultTray.AddMenuItemCheckbox(title, tooltip, checked)
+}
+
+// AddMenuItemRadio adds a menu item to a group of mutually exclusive items of the default tray,
+// see Tray.AddMenuItemRadio.
+func AddMenuItemRadio(group string, title string, tooltip string) *MenuItem {
+	return defaultTray.AddMenuItemRadio(group, title, tooltip)
+}
+
+// AddMenuItemAt adds a menu item at index in the menu of the default tray, see Tray.AddMenuItemAt.
+func AddMenuItemAt(index int, title string, tooltip string) *MenuItem {
+	return defaultTray.AddMenuItemAt(index, title, tooltip)
+}
+
+// AddSeparator adds a separator bar to the menu of the default tray
+func AddSeparator() {
+	defaultTray.AddSeparator()
+}
+
+// ResetMenu will remove all menu items of the default tray
+func ResetMenu() {
+	defaultTray.ResetMenu()
+}
+
+// SetMenu sets the menu of the default tray to the one described, see Tray.SetMenu.
+func SetMenu(menu Menu) {
+	defaultTray.SetMenu(menu)
+}
@@ -7,11 +7,8 @@
 package systray
 
 import (
-	"bytes"
 	"fmt"
 	"image"
-	_ "image/png" // used only here
-	"log"
 	"os"
 	"sync"
 
@@ -23,132 +20,351 @@ import (
 	"fyne.io/systray/internal/generated/notifier"
 )
 
-const (
-	path     = "/StatusNotifierItem"
-	menuPath = "/StatusNotifierItem/menu"
-)
+// path is the object path of the StatusNotifierItem of the default tray, the one hosts look
+// for on a bus name
+const path = "/StatusNotifierItem"
 
 var (
-	// to signal quitting the internal main loop
+	// to signal quitting the internal main loop, it is made again by each call to Run
 	quitChan = make(chan struct{})
-
-	// instance is the current instance of our DBus tray server
-	instance = &tray{menu: &menuLayout{}, menuVersion: 1}
+	// busOptions are the options of the last call to RunContext, they are reset by Run
+	busOptions nativeOptions
 )
 
-// SetTemplateIcon sets the systray icon as a template icon (on macOS), falling back
+// nativeOptions holds the options of RunContext specific to Linux
+type nativeOptions struct {
+	conn    *dbus.Conn
+	address string
+}
+
+// WithDBusConn makes the trays use conn instead of connections to the session bus of their
+// own, only available on Linux. The connection is left open when the tray quits.
+// As watchers identify the trays added by NewTray by the connection, hosts may keep showing
+// them once hidden or removed until the connection is closed.
+func WithDBusConn(conn *dbus.Conn) Option {
+	return func(o *runOptions) {
+		o.native.conn = conn
+	}
+}
+
+// WithBusAddress makes the trays connect to the bus at address instead of the session bus,
+// only available on Linux. It is useful to run the tray against a dedicated dbus-daemon.
+func WithBusAddress(address string) Option {
+	return func(o *runOptions) {
+		o.native.address = address
+	}
+}
+
+func newNativeTray(owner *Tray) *nativeTray {
+	return &nativeTray{owner: owner, menu: &menuLayout{}, menuVersion: 1}
+}
+
+// SetTemplateIcon sets the tray icon as a template icon (on macOS), falling back
 // to a regular icon on other platforms.
 // templateIconBytes and iconBytes should be the content of .ico for windows and
 // .ico/.jpg/.png for other platforms.
-func SetTemplateIcon(templateIconBytes []byte, regularIconBytes []byte) {
+func (t *Tray) SetTemplateIcon(templateIconBytes []byte, regularIconBytes []byte) {
+	logFailure("failed to set template icon", t.TrySetTemplateIcon(templateIconBytes, regularIconBytes), "tray", t.id)
+}
+
+// TrySetTemplateIcon is like SetTemplateIcon but returns an error on failure.
+func (t *Tray) TrySetTemplateIcon(templateIconBytes []byte, regularIconBytes []byte) error {
+	return currentBackend().setTemplateIcon(t, templateIconBytes, regularIconBytes)
+}
+
+func (b nativeBackend) setTemplateIcon(t *Tray, templateIconBytes, regularIconBytes []byte) error {
 	// TODO handle the templateIconBytes?
-	SetIcon(regularIconBytes)
+	return b.setIcon(t, regularIconBytes)
 }
 
-// SetIcon sets the systray icon.
+// SetIcon sets the tray icon.
 // iconBytes should be the content of .ico for windows and .ico/.jpg/.png
-// for other platforms.
-func SetIcon(iconBytes []byte) {
-	instance.lock.Lock()
-	instance.iconData = iconBytes
-	props := instance.props
-	conn := instance.conn
-	defer instance.lock.Unlock()
-
-	if props == nil {
-		return
-	}
+// for other platforms. Empty iconBytes remove the icon.
+// A .png or .jpg image is downscaled to the standard icon sizes each time it is set, use a
+// .ico or SetIconSet to provide the sizes instead.
+func (t *Tray) SetIcon(iconBytes []byte) {
+	logFailure("failed to set icon", t.TrySetIcon(iconBytes), "tray", t.id)
+}
 
-	props.SetMust("org.kde.StatusNotifierItem", "IconPixmap",
-		[]PX{convertToPixels(iconBytes)})
-	if conn == nil {
-		return
+// TrySetIcon is like SetIcon but returns an error on failure.
+func (t *Tray) TrySetIcon(iconBytes []byte) error {
+	return currentBackend().setIcon(t, iconBytes)
+}
+
+func (nativeBackend) setIcon(t *Tray, iconBytes []byte) error {
+	pixels, err := pixelsFor(iconBytes)
+	if err != nil {
+		return err
 	}
 
-	err := notifier.Emit(conn, &notifier.StatusNotifierItem_NewIconSignal{
-		Path: path,
+	n := t.native
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.iconData = iconBytes
+	return n.setProp("IconPixmap", pixels, &notifier.StatusNotifierItem_NewIconSignal{
+		Path: n.itemPath(),
 		Body: &notifier.StatusNotifierItem_NewIconSignalBody{},
 	})
+}
+
+// SetAttentionIcon sets the icon shown instead of the regular one while the status is
+// StatusNeedsAttention.
+// iconBytes should be the content of .ico for windows and .ico/.jpg/.png
+// for other platforms. Empty iconBytes remove the attention icon.
+func (t *Tray) SetAttentionIcon(iconBytes []byte) {
+	logFailure("failed to set attention icon", t.TrySetAttentionIcon(iconBytes), "tray", t.id)
+}
+
+// TrySetAttentionIcon is like SetAttentionIcon but returns an error on failure.
+func (t *Tray) TrySetAttentionIcon(iconBytes []byte) error {
+	return currentBackend().setAttentionIcon(t, iconBytes)
+}
+
+func (nativeBackend) setAttentionIcon(t *Tray, iconBytes []byte) error {
+	pixels, err := pixelsFor(iconBytes)
+	if err != nil {
+		return err
+	}
+
+	n := t.native
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.attentionIconData = iconBytes
+	return n.setProp("AttentionIconPixmap", pixels, &notifier.StatusNotifierItem_NewAttentionIconSignal{
+		Path: n.itemPath(),
+		Body: &notifier.StatusNotifierItem_NewAttentionIconSignalBody{},
+	})
+}
+
+// SetOverlayIcon sets a small icon, such as a badge, drawn over the bottom right
+// corner of the tray icon.
+// iconBytes should be the content of .ico for windows and .ico/.jpg/.png
+// for other platforms.
+func (t *Tray) SetOverlayIcon(iconBytes []byte) {
+	logFailure("failed to set overlay icon", t.TrySetOverlayIcon(iconBytes), "tray", t.id)
+}
+
+// TrySetOverlayIcon is like SetOverlayIcon but returns an error on failure.
+// Empty iconBytes remove the overlay icon.
+func (t *Tray) TrySetOverlayIcon(iconBytes []byte) error {
+	return currentBackend().setOverlayIcon(t, iconBytes)
+}
+
+func (nativeBackend) setOverlayIcon(t *Tray, iconBytes []byte) error {
+	pixels, err := pixelsFor(iconBytes)
 	if err != nil {
-		log.Printf("systray error: failed to emit new icon signal: %s\n", err)
-		return
+		return err
+	}
+
+	n := t.native
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.overlayIconData = iconBytes
+	return n.setProp("OverlayIconPixmap", pixels, &notifier.StatusNotifierItem_NewOverlayIconSignal{
+		Path: n.itemPath(),
+		Body: &notifier.StatusNotifierItem_NewOverlayIconSignalBody{},
+	})
+}
+
+// ClearOverlayIcon removes the icon set by SetOverlayIcon.
+func (t *Tray) ClearOverlayIcon() {
+	t.SetOverlayIcon(nil)
+}
+
+// SetIconName sets the tray icon by name from the desktop icon theme, only available on Linux.
+// Hosts prefer a named icon over the one set by SetIcon, an empty name reverts to that one.
+func (t *Tray) SetIconName(name string) {
+	logFailure("failed to set icon name", t.TrySetIconName(name), "tray", t.id)
+}
+
+// TrySetIconName is like SetIconName but returns an error on failure.
+func (t *Tray) TrySetIconName(name string) error {
+	return currentBackend().setIconName(t, name)
+}
+
+func (nativeBackend) setIconName(t *Tray, name string) error {
+	n := t.native
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.iconName = name
+	return n.setProp("IconName", name, &notifier.StatusNotifierItem_NewIconSignal{
+		Path: n.itemPath(),
+		Body: &notifier.StatusNotifierItem_NewIconSignalBody{},
+	})
+}
+
+// SetAttentionIconName sets the icon shown instead of the regular one while the status is
+// StatusNeedsAttention by name from the desktop icon theme, only available on Linux.
+// Hosts prefer a named icon over the one set by SetAttentionIcon, an empty name reverts to that one.
+func (t *Tray) SetAttentionIconName(name string) {
+	logFailure("failed to set attention icon name", t.TrySetAttentionIconName(name), "tray", t.id)
+}
+
+// TrySetAttentionIconName is like SetAttentionIconName but returns an error on failure.
+func (t *Tray) TrySetAttentionIconName(name string) error {
+	return currentBackend().setAttentionIconName(t, name)
+}
+
+func (nativeBackend) setAttentionIconName(t *Tray, name string) error {
+	n := t.native
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.attentionIconName = name
+	return n.setProp("AttentionIconName", name, &notifier.StatusNotifierItem_NewAttentionIconSignal{
+		Path: n.itemPath(),
+		Body: &notifier.StatusNotifierItem_NewAttentionIconSignalBody{},
+	})
+}
+
+// SetOverlayIconName sets the icon drawn over the tray icon by name from the desktop icon theme,
+// only available on Linux.
+// Hosts prefer a named icon over the one set by SetOverlayIcon, an empty name reverts to that one.
+func (t *Tray) SetOverlayIconName(name string) {
+	logFailure("failed to set overlay icon name", t.TrySetOverlayIconName(name), "tray", t.id)
+}
+
+// TrySetOverlayIconName is like SetOverlayIconName but returns an error on failure.
+func (t *Tray) TrySetOverlayIconName(name string) error {
+	return currentBackend().setOverlayIconName(t, name)
+}
+
+func (nativeBackend) setOverlayIconName(t *Tray, name string) error {
+	n := t.native
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.overlayIconName = name
+	return n.setProp("OverlayIconName", name, &notifier.StatusNotifierItem_NewOverlayIconSignal{
+		Path: n.itemPath(),
+		Body: &notifier.StatusNotifierItem_NewOverlayIconSignalBody{},
+	})
+}
+
+// SetIconThemePath adds a directory to the icon theme search path used to find icons set by
+// SetIconName and MenuItem.SetIconName, only available on Linux.
+func (t *Tray) SetIconThemePath(dir string) {
+	logFailure("failed to set icon theme path", t.TrySetIconThemePath(dir), "tray", t.id)
+}
+
+// TrySetIconThemePath is like SetIconThemePath but returns an error on failure.
+func (t *Tray) TrySetIconThemePath(dir string) error {
+	return currentBackend().setIconThemePath(t, dir)
+}
+
+func (nativeBackend) setIconThemePath(t *Tray, dir string) error {
+	n := t.native
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.iconThemePath = dir
+	if n.menuProps != nil {
+		dbusErr := n.menuProps.Set("com.canonical.dbusmenu", "IconThemePath",
+			dbus.MakeVariant(iconThemePaths(dir)))
+		if dbusErr != nil {
+			return fmt.Errorf("failed to set menu IconThemePath prop: %w", dbusErr)
+		}
 	}
+	return n.setProp("IconThemePath", dir, &notifier.StatusNotifierItem_NewIconThemePathSignal{
+		Path: n.itemPath(),
+		Body: &notifier.StatusNotifierItem_NewIconThemePathSignalBody{IconThemePath: dir},
+	})
 }
 
-// SetIconFromFilePath sets the systray icon from a file path.
+// SetIconFromFilePath sets the tray icon from a file path.
 // iconFilePath should be the path to a .ico for windows and .ico/.jpg/.png for other platforms.
-func SetIconFromFilePath(iconFilePath string) error {
+func (t *Tray) SetIconFromFilePath(iconFilePath string) error {
 	bytes, err := os.ReadFile(iconFilePath)
 	if err != nil {
 		return fmt.Errorf("failed to read icon file: %v", err)
 	}
-	SetIcon(bytes)
+	t.SetIcon(bytes)
 	return nil
 }
 
-// SetTitle sets the systray title, only available on Mac and Linux.
-func SetTitle(t string) {
-	instance.lock.Lock()
-	instance.title = t
-	props := instance.props
-	conn := instance.conn
-	defer instance.lock.Unlock()
-
-	if props == nil {
-		return
-	}
-	dbusErr := props.Set("org.kde.StatusNotifierItem", "Title",
-		dbus.MakeVariant(t))
-	if dbusErr != nil {
-		log.Printf("systray error: failed to set Title prop: %s\n", dbusErr)
-		return
-	}
+// SetTitle sets the tray title, only available on Mac and Linux.
+func (t *Tray) SetTitle(title string) {
+	logFailure("failed to set title", t.TrySetTitle(title), "tray", t.id)
+}
 
-	if conn == nil {
-		return
-	}
+// TrySetTitle is like SetTitle but returns an error on failure.
+func (t *Tray) TrySetTitle(title string) error {
+	return currentBackend().setTitle(t, title)
+}
 
-	err := notifier.Emit(conn, &notifier.StatusNotifierItem_NewTitleSignal{
-		Path: path,
+func (nativeBackend) setTitle(t *Tray, title string) error {
+	n := t.native
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.title = title
+	return n.setProp("Title", title, &notifier.StatusNotifierItem_NewTitleSignal{
+		Path: n.itemPath(),
 		Body: &notifier.StatusNotifierItem_NewTitleSignalBody{},
 	})
-	if err != nil {
-		log.Printf("systray error: failed to emit new title signal: %s\n", err)
-		return
-	}
 }
 
-// SetTooltip sets the systray tooltip to display on mouse hover of the tray icon,
+// SetTooltip sets the tray tooltip to display on mouse hover of the tray icon,
 // only available on Mac and Windows.
-func SetTooltip(tooltipTitle string) {
-	instance.lock.Lock()
-	instance.tooltipTitle = tooltipTitle
-	props := instance.props
-	conn := instance.conn
-	defer instance.lock.Unlock()
-
-	if props == nil {
-		return
+func (t *Tray) SetTooltip(tooltipTitle string) {
+	logFailure("failed to set tooltip", t.TrySetTooltip(tooltipTitle), "tray", t.id)
+}
+
+// TrySetTooltip is like SetTooltip but returns an error on failure.
+func (t *Tray) TrySetTooltip(tooltipTitle string) error {
+	return currentBackend().setTooltip(t, tooltipTitle)
+}
+
+func (nativeBackend) setTooltip(t *Tray, tooltipTitle string) error {
+	n := t.native
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.tooltipTitle = tooltipTitle
+	return n.setProp("ToolTip", tooltip{V2: tooltipTitle}, &notifier.StatusNotifierItem_NewToolTipSignal{
+		Path: n.itemPath(),
+		Body: &notifier.StatusNotifierItem_NewToolTipSignalBody{},
+	})
+}
+
+// SetStatus sets the status of the tray icon.
+// Hosts may hide a passive icon or highlight one that needs attention.
+func (t *Tray) SetStatus(status Status) {
+	logFailure("failed to set status", t.TrySetStatus(status), "tray", t.id)
+}
+
+// TrySetStatus is like SetStatus but returns an error on failure.
+func (t *Tray) TrySetStatus(status Status) error {
+	return currentBackend().setStatus(t, status)
+}
+
+func (nativeBackend) setStatus(t *Tray, status Status) error {
+	n := t.native
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.status = status
+	return n.setProp("Status", status.String(), &notifier.StatusNotifierItem_NewStatusSignal{
+		Path: n.itemPath(),
+		Body: &notifier.StatusNotifierItem_NewStatusSignalBody{Status: status.String()},
+	})
+}
+
+// setProp sets a property of the StatusNotifierItem and emits the signal announcing it.
+// Before the tray is ready, the property is only exported from the saved state once it is.
+// Without a host, the property is still set and shown once one appears, see HostAvailable.
+// The lock of the tray must be held.
+func (t *nativeTray) setProp(property string, value interface{}, signal notifier.Signal) error {
+	if t.props == nil {
+		return nil
 	}
-	dbusErr := props.Set("org.kde.StatusNotifierItem", "ToolTip",
-		dbus.MakeVariant(tooltip{V2: tooltipTitle}))
+	dbusErr := t.props.Set("org.kde.StatusNotifierItem", property, dbus.MakeVariant(value))
 	if dbusErr != nil {
-		log.Printf("systray error: failed to set ToolTip prop: %s\n", dbusErr)
-		return
+		return fmt.Errorf("failed to set %s prop: %w", property, dbusErr)
 	}
 
-	if conn == nil {
-		return
+	if t.conn == nil {
+		return nil
 	}
-
-	err := notifier.Emit(conn, &notifier.StatusNotifierItem_NewToolTipSignal{
-		Path: path,
-		Body: &notifier.StatusNotifierItem_NewToolTipSignalBody{},
-	})
+	err := notifier.Emit(t.conn, signal)
 	if err != nil {
-		log.Printf("systray error: failed to emit new tooltip signal: %s\n", err)
-		return
+		return fmt.Errorf("failed to emit %s signal: %w", signal.Name(), err)
 	}
+	return nil
 }
 
 // SetTemplateIcon sets the icon of a menu item as a template icon (on macOS). On Windows and
@@ -156,19 +372,31 @@ func SetTooltip(tooltipTitle string) {
 // templateIconBytes and regularIconBytes should be the content of .ico for windows and
 // .ico/.jpg/.png for other platforms.
 func (item *MenuItem) SetTemplateIcon(templateIconBytes []byte, regularIconBytes []byte) {
-	item.SetIcon(regularIconBytes)
+	logFailure("failed to set menu item template icon", item.TrySetTemplateIcon(templateIconBytes, regularIconBytes), "item", item.id)
+}
+
+// TrySetTemplateIcon is like SetTemplateIcon but returns an error on failure.
+func (item *MenuItem) TrySetTemplateIcon(templateIconBytes []byte, regularIconBytes []byte) error {
+	return currentBackend().setMenuItemTemplateIcon(item, templateIconBytes, regularIconBytes)
 }
 
-// SetRemovalAllowed sets whether a user can remove the systray icon or not.
+func (b nativeBackend) setMenuItemTemplateIcon(item *MenuItem, templateIconBytes, regularIconBytes []byte) error {
+	return b.setMenuItemIcon(item, regularIconBytes)
+}
+
+// SetRemovalAllowed sets whether a user can remove the tray icon or not.
 // This is only supported on macOS.
-func SetRemovalAllowed(allowed bool) {
+func (t *Tray) SetRemovalAllowed(allowed bool) {
 }
 
 func setInternalLoop(_ bool) {
 	// nothing to action on Linux
 }
 
-func registerSystray() {
+func registerSystray(o nativeOptions) error {
+	quitChan = make(chan struct{})
+	busOptions = o
+	return nil
 }
 
 func nativeLoop() int {
@@ -178,9 +406,19 @@ func nativeLoop() int {
 	return 0
 }
 
+// nativeRun is like nativeLoop but it returns right away if the default tray cannot be created
+func nativeRun() error {
+	err := startSystray()
+	if err == nil {
+		<-quitChan
+	}
+	nativeEnd()
+	return err
+}
+
 func nativeEnd() {
 	runSystrayExit()
-	instance.conn.Close()
+	stopTrays()
 }
 
 func quit() {
@@ -188,157 +426,394 @@ func quit() {
 }
 
 func nativeStart() {
+	if err := startSystray(); err != nil {
+		logError("failed to create tray", "error", err)
+	}
+}
+
+// startSystray calls onReady and creates the trays, the other trays are not created when the
+// default one cannot be
+func startSystray() error {
 	systrayReady()
-	conn, err := dbus.SessionBus()
+	if err := defaultTray.create(); err != nil {
+		return err
+	}
+	startTrays()
+	return nil
+}
+
+// create exports the DBus objects of the tray and registers it with the StatusNotifierWatcher,
+// unless the tray is hidden. Each tray gets a connection of its own so that the watcher drops
+// its item once the connection is closed, unless one is given to WithDBusConn.
+func (t *Tray) create() error {
+	if t.hidden.Load() {
+		return nil
+	}
+	n := t.native
+	n.lock.Lock()
+	created := n.conn != nil
+	n.lock.Unlock()
+	if created {
+		return nil
+	}
+
+	conn, shared, err := n.connect()
 	if err != nil {
-		log.Printf("systray error: failed to connect to DBus: %v\n", err)
-		return
+		return fmt.Errorf("failed to connect to DBus: %w", err)
+	}
+	err = n.export(conn, shared)
+	if err != nil {
+		if shared {
+			logFailure("failed to clean up", n.unexport(conn), "tray", t.id)
+		} else {
+			conn.Close()
+		}
+		return err
+	}
+	n.register()
+
+	n.lock.Lock()
+	done := n.done
+	n.lock.Unlock()
+	n.watching.Add(1)
+	go func() {
+		defer n.watching.Done()
+		n.stayRegistered(conn, done)
+	}()
+	return nil
+}
+
+// destroy removes the objects of the tray from the bus and closes its connection unless shared
+func (t *Tray) destroy() error {
+	n := t.native
+	n.lock.Lock()
+	conn, shared, done := n.conn, n.sharedConn, n.done
+	n.conn, n.props, n.menuProps, n.registered, n.done = nil, nil, nil, false, nil
+	n.lock.Unlock()
+	if conn == nil {
+		return nil
+	}
+	close(done)
+	n.watching.Wait()
+
+	err := n.unexport(conn)
+	if shared {
+		return err
+	}
+	if closeErr := conn.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to close DBus connection: %w", closeErr)
+	}
+	return err
+}
+
+// setVisible exports the tray again, or removes it from the bus while keeping its state
+func (t *Tray) setVisible(visible bool) error {
+	if visible {
+		return t.create()
 	}
-	err = notifier.ExportStatusNotifierItem(conn, path, newLeftRightNotifierItem())
+	return t.destroy()
+}
+
+// connect returns the connection to use for the tray and whether it is shared with the
+// application and the other trays, as the one given to WithDBusConn
+func (t *nativeTray) connect() (*dbus.Conn, bool, error) {
+	o := busOptions
+	if o.conn != nil {
+		return o.conn, true, nil
+	}
+	var conn *dbus.Conn
+	var err error
+	if o.address != "" {
+		conn, err = dbus.Connect(o.address)
+	} else {
+		conn, err = dbus.ConnectSessionBus()
+	}
+	return conn, false, err
+}
+
+// busName returns the name requested by the tray, the default tray having id 1 for this process
+func (t *nativeTray) busName() string {
+	return fmt.Sprintf("org.kde.StatusNotifierItem-%d-%d", os.Getpid(), t.owner.id)
+}
+
+// itemPath returns the object path of the StatusNotifierItem of the tray, unique to the tray
+// so that trays can share a connection
+func (t *nativeTray) itemPath() dbus.ObjectPath {
+	if t.owner == defaultTray {
+		return path
+	}
+	return dbus.ObjectPath(fmt.Sprintf("%s/%d", path, t.owner.id))
+}
+
+// menuPath returns the object path of the dbusmenu of the tray
+func (t *nativeTray) menuPath() dbus.ObjectPath {
+	return t.itemPath() + "/menu"
+}
+
+// export serves the StatusNotifierItem and its menu on conn under a name unique to the tray
+func (t *nativeTray) export(conn *dbus.Conn, shared bool) error {
+	err := notifier.ExportStatusNotifierItem(conn, t.itemPath(), &leftRightNotifierItem{tray: t.owner})
 	if err != nil {
-		log.Printf("systray error: failed to export status notifier item: %v\n", err)
+		return fmt.Errorf("failed to export status notifier item: %w", err)
 	}
-	err = menu.ExportDbusmenu(conn, menuPath, instance)
+	err = menu.ExportDbusmenu(conn, t.menuPath(), t)
 	if err != nil {
-		log.Printf("systray error: failed to export status notifier menu: %v\n", err)
-		return
+		return fmt.Errorf("failed to export status notifier menu: %w", err)
 	}
 
-	name := fmt.Sprintf("org.kde.StatusNotifierItem-%d-1", os.Getpid()) // register id 1 for this process
+	name := t.busName()
 	_, err = conn.RequestName(name, dbus.NameFlagDoNotQueue)
 	if err != nil {
-		log.Printf("systray error: failed to request name: %s\n", err)
+		logError("failed to request name", "name", name, "error", err)
 		// it's not critical error: continue
 	}
-	props, err := prop.Export(conn, path, instance.createPropSpec())
+	props, err := prop.Export(conn, t.itemPath(), t.createPropSpec())
 	if err != nil {
-		log.Printf("systray error: failed to export notifier item properties to bus: %s\n", err)
-		return
+		return fmt.Errorf("failed to export notifier item properties to bus: %w", err)
 	}
-	menuProps, err := prop.Export(conn, menuPath, createMenuPropSpec())
+	menuProps, err := prop.Export(conn, t.menuPath(), t.createMenuPropSpec())
 	if err != nil {
-		log.Printf("systray error: failed to export notifier menu properties to bus: %s\n", err)
-		return
+		return fmt.Errorf("failed to export notifier menu properties to bus: %w", err)
 	}
 
 	node := introspect.Node{
-		Name: path,
+		Name: string(t.itemPath()),
 		Interfaces: []introspect.Interface{
 			introspect.IntrospectData,
 			prop.IntrospectData,
 			notifier.IntrospectDataStatusNotifierItem,
 		},
 	}
-	err = conn.Export(introspect.NewIntrospectable(&node), path,
+	err = conn.Export(introspect.NewIntrospectable(&node), t.itemPath(),
 		"org.freedesktop.DBus.Introspectable")
 	if err != nil {
-		log.Printf("systray error: failed to export node introspection: %s\n", err)
-		return
+		return fmt.Errorf("failed to export node introspection: %w", err)
 	}
 	menuNode := introspect.Node{
-		Name: menuPath,
+		Name: string(t.menuPath()),
 		Interfaces: []introspect.Interface{
 			introspect.IntrospectData,
 			prop.IntrospectData,
 			menu.IntrospectDataDbusmenu,
 		},
 	}
-	err = conn.Export(introspect.NewIntrospectable(&menuNode), menuPath,
+	err = conn.Export(introspect.NewIntrospectable(&menuNode), t.menuPath(),
 		"org.freedesktop.DBus.Introspectable")
 	if err != nil {
-		log.Printf("systray error: failed to export menu node introspection: %s\n", err)
-		return
+		return fmt.Errorf("failed to export menu node introspection: %w", err)
 	}
 
-	instance.lock.Lock()
-	instance.conn = conn
-	instance.props = props
-	instance.menuProps = menuProps
-	instance.lock.Unlock()
+	t.lock.Lock()
+	t.conn = conn
+	t.sharedConn = shared
+	t.props = props
+	t.menuProps = menuProps
+	t.done = make(chan struct{})
+	t.lock.Unlock()
+	return nil
+}
 
-	go stayRegistered()
+// unexport removes the objects served by export from conn and releases the name of the tray
+func (t *nativeTray) unexport(conn *dbus.Conn) error {
+	if err := notifier.UnexportStatusNotifierItem(conn, t.itemPath()); err != nil {
+		return fmt.Errorf("failed to unexport status notifier item: %w", err)
+	}
+	if err := menu.UnexportDbusmenu(conn, t.menuPath()); err != nil {
+		return fmt.Errorf("failed to unexport status notifier menu: %w", err)
+	}
+	for _, p := range []dbus.ObjectPath{t.itemPath(), t.menuPath()} {
+		for _, iface := range []string{"org.freedesktop.DBus.Properties", "org.freedesktop.DBus.Introspectable"} {
+			if err := conn.Export(nil, p, iface); err != nil {
+				return fmt.Errorf("failed to unexport %s: %w", iface, err)
+			}
+		}
+	}
+	if _, err := conn.ReleaseName(t.busName()); err != nil {
+		return fmt.Errorf("failed to release name: %w", err)
+	}
+	return nil
 }
 
-func register() bool {
-	obj := instance.conn.Object("org.kde.StatusNotifierWatcher", "/StatusNotifierWatcher")
-	call := obj.Call("org.kde.StatusNotifierWatcher.RegisterStatusNotifierItem", 0, path)
-	if call.Err != nil {
-		log.Printf("systray error: failed to register: %v\n", call.Err)
-		return false
+// register registers the item of the tray with the StatusNotifierWatcher
+func (t *nativeTray) register() {
+	t.lock.Lock()
+	conn := t.conn
+	t.lock.Unlock()
+	if conn == nil {
+		return
 	}
 
-	return true
+	// the default tray is registered by name, so that releasing the name drops it even when the
+	// connection stays open, hosts only look for the default path on a name
+	service := string(t.itemPath())
+	if t.owner == defaultTray {
+		service = t.busName()
+	}
+	obj := conn.Object("org.kde.StatusNotifierWatcher", "/StatusNotifierWatcher")
+	call := obj.Call("org.kde.StatusNotifierWatcher.RegisterStatusNotifierItem", 0, service)
+	registered := call.Err == nil
+	t.lock.Lock()
+	t.registered = registered
+	t.lock.Unlock()
+	if !registered {
+		logError("failed to register", "method", "org.kde.StatusNotifierWatcher.RegisterStatusNotifierItem",
+			"tray", t.owner.id, "error", call.Err)
+	}
+	t.checkHost()
 }
 
-func stayRegistered() {
-	register()
+// setUnregistered records that the watcher went away, taking the registration with it
+func (t *nativeTray) setUnregistered() {
+	t.lock.Lock()
+	t.registered = false
+	t.lock.Unlock()
+	t.owner.setHostAvailable(false)
+}
 
-	conn := instance.conn
-	if err := conn.AddMatchSignal(
+// checkHost updates HostAvailable from the IsStatusNotifierHostRegistered property of the watcher
+func (t *nativeTray) checkHost() {
+	t.lock.Lock()
+	registered, conn := t.registered, t.conn
+	t.lock.Unlock()
+	if !registered {
+		t.owner.setHostAvailable(false)
+		return
+	}
+
+	obj := conn.Object("org.kde.StatusNotifierWatcher", "/StatusNotifierWatcher")
+	v, err := obj.GetProperty("org.kde.StatusNotifierWatcher.IsStatusNotifierHostRegistered")
+	if err != nil {
+		// not every watcher has the property, being registered is the best hint then
+		t.owner.setHostAvailable(true)
+		return
+	}
+	hosted, ok := v.Value().(bool)
+	t.owner.setHostAvailable(!ok || hosted)
+}
+
+// stayRegistered registers the tray again each time a StatusNotifierWatcher appears, until
+// done is closed
+func (t *nativeTray) stayRegistered(conn *dbus.Conn, done chan struct{}) {
+	// the rules are removed afterwards as a shared connection stays open
+	watcherRule := []dbus.MatchOption{
 		dbus.WithMatchObjectPath("/org/freedesktop/DBus"),
 		dbus.WithMatchInterface("org.freedesktop.DBus"),
 		dbus.WithMatchSender("org.freedesktop.DBus"),
 		dbus.WithMatchMember("NameOwnerChanged"),
 		dbus.WithMatchArg(0, "org.kde.StatusNotifierWatcher"),
-	); err != nil {
-		log.Printf("systray error: failed to register signal matching: %v\n", err)
+	}
+	if err := conn.AddMatchSignal(watcherRule...); err != nil {
+		logError("failed to register signal matching", "error", err)
 		// If we can't monitor signals, there is no point in
 		// us being here. we're either registered or not (per
 		// above) and will roll the dice from here...
 		return
 	}
+	defer conn.RemoveMatchSignal(watcherRule...)
+	for _, member := range []string{"StatusNotifierHostRegistered", "StatusNotifierHostUnregistered"} {
+		hostRule := []dbus.MatchOption{
+			dbus.WithMatchObjectPath("/StatusNotifierWatcher"),
+			dbus.WithMatchInterface("org.kde.StatusNotifierWatcher"),
+			dbus.WithMatchMember(member),
+		}
+		if err := conn.AddMatchSignal(hostRule...); err != nil {
+			logWarn("failed to register signal matching", "signal", member, "error", err)
+			continue
+		}
+		defer conn.RemoveMatchSignal(hostRule...)
+	}
 
 	sc := make(chan *dbus.Signal, 10)
 	conn.Signal(sc)
+	defer conn.RemoveSignal(sc)
 
 	for {
 		select {
 		case sig := <-sc:
 			if sig == nil {
 				return // We get a nil signal when closing the window.
-			} else if len(sig.Body) < 3 {
-				return // malformed signal?
 			}
 
-			// sig.Body has the args, which are [name old_owner new_owner]
-			if s, ok := sig.Body[2].(string); ok && s != "" {
-				register()
+			switch sig.Name {
+			case "org.freedesktop.DBus.NameOwnerChanged":
+				if len(sig.Body) < 3 {
+					continue // malformed signal?
+				}
+				// sig.Body has the args, which are [name old_owner new_owner], a shared
+				// connection may also receive the signals matched by the application
+				if name, _ := sig.Body[0].(string); name != "org.kde.StatusNotifierWatcher" {
+					continue
+				}
+				if s, ok := sig.Body[2].(string); ok && s != "" {
+					t.register()
+				} else {
+					t.setUnregistered()
+				}
+			case "org.kde.StatusNotifierWatcher.StatusNotifierHostRegistered",
+				"org.kde.StatusNotifierWatcher.StatusNotifierHostUnregistered":
+				t.checkHost()
 			}
-		case <-quitChan:
+		case <-done:
 			return
 		}
 	}
 }
 
-// tray is a basic type that handles the dbus functionality
-type tray struct {
+// nativeTray is a basic type that handles the dbus functionality of a Tray
+type nativeTray struct {
+	// owner is the tray served
+	owner *Tray
 	// the DBus connection that we will use
 	conn *dbus.Conn
+	// sharedConn is true if conn belongs to the application, it is then left open
+	sharedConn bool
 
 	// icon data for the main systray icon
 	iconData []byte
+	// icon name and theme path for a themed main icon
+	iconName, iconThemePath string
+	// icon data and name shown by hosts while the status is StatusNeedsAttention
+	attentionIconData []byte
+	attentionIconName string
+	// icon data and name drawn by hosts over the main icon
+	overlayIconData []byte
+	overlayIconName string
 	// title and tooltip state
 	title, tooltipTitle string
+	// status of the icon, exported as the Status property
+	status Status
+	// registered is true once the item is registered with the StatusNotifierWatcher
+	registered bool
+	// done is closed once the connection is given up, watching waits for stayRegistered to
+	// return then
+	done     chan struct{}
+	watching sync.WaitGroup
 
 	lock             sync.Mutex
 	menu             *menuLayout
 	menuLock         sync.RWMutex
 	props, menuProps *prop.Properties
 	menuVersion      uint32
+	// batch collects the signals of the menu while SetMenu reconciles it, it is protected
+	// by menuLock
+	batch *menuBatch
 }
 
-func (t *tray) createPropSpec() map[string]map[string]*prop.Prop {
+func (t *nativeTray) createPropSpec() map[string]map[string]*prop.Prop {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 	id := t.title
 	if id == "" {
 		id = fmt.Sprintf("systray_%d", os.Getpid())
 	}
+	if t.owner != defaultTray {
+		id = fmt.Sprintf("%s_%d", id, t.owner.id)
+	}
 	return map[string]map[string]*prop.Prop{
 		"org.kde.StatusNotifierItem": {
 			"Status": {
-				Value:    "Active", // Passive, Active or NeedsAttention
-				Writable: false,
+				Value:    t.status.String(),
+				Writable: true,
 				Emit:     prop.EmitTrue,
 				Callback: nil,
 			},
@@ -361,31 +836,55 @@ func (t *tray) createPropSpec() map[string]map[string]*prop.Prop {
 				Callback: nil,
 			},
 			"IconName": {
-				Value:    "",
-				Writable: false,
+				Value:    t.iconName,
+				Writable: true,
 				Emit:     prop.EmitTrue,
 				Callback: nil,
 			},
 			"IconPixmap": {
-				Value:    []PX{convertToPixels(t.iconData)},
+				Value:    convertToPixels(t.iconData),
 				Writable: true,
 				Emit:     prop.EmitTrue,
 				Callback: nil,
 			},
 			"IconThemePath": {
-				Value:    "",
-				Writable: false,
+				Value:    t.iconThemePath,
+				Writable: true,
+				Emit:     prop.EmitTrue,
+				Callback: nil,
+			},
+			"OverlayIconName": {
+				Value:    t.overlayIconName,
+				Writable: true,
+				Emit:     prop.EmitTrue,
+				Callback: nil,
+			},
+			"OverlayIconPixmap": {
+				Value:    convertToPixels(t.overlayIconData),
+				Writable: true,
+				Emit:     prop.EmitTrue,
+				Callback: nil,
+			},
+			"AttentionIconName": {
+				Value:    t.attentionIconName,
+				Writable: true,
+				Emit:     prop.EmitTrue,
+				Callback: nil,
+			},
+			"AttentionIconPixmap": {
+				Value:    convertToPixels(t.attentionIconData),
+				Writable: true,
 				Emit:     prop.EmitTrue,
 				Callback: nil,
 			},
 			"ItemIsMenu": {
-				Value:    tappedLeft == nil && tappedRight == nil,
+				Value:    t.owner.tappedLeftHandler() == nil && t.owner.tappedRightHandler() == nil,
 				Writable: false,
 				Emit:     prop.EmitTrue,
 				Callback: nil,
 			},
 			"Menu": {
-				Value:    dbus.ObjectPath(menuPath),
+				Value:    t.menuPath(),
 				Writable: true,
 				Emit:     prop.EmitTrue,
 				Callback: nil,
@@ -414,21 +913,39 @@ type tooltip = struct {
 	V3 string // description
 }
 
-func convertToPixels(data []byte) PX {
-	if len(data) == 0 {
-		return PX{}
+// convertToPixels returns a pixmap for every image in data. When data isn't a .ico,
+// downscaled copies of its image in the standard icon sizes are added so hosts don't have to.
+func convertToPixels(data []byte) []PX {
+	pixels, err := pixelsFor(data)
+	if err != nil {
+		logWarn("failed to read icon format", "error", err)
+		return []PX{}
 	}
+	return pixels
+}
 
-	img, _, err := image.Decode(bytes.NewReader(data))
+// pixelsFor is like convertToPixels but returns an error wrapping ErrInvalidIcon if data cannot be decoded.
+// Empty data has no pixmap, removing the icon.
+func pixelsFor(data []byte) ([]PX, error) {
+	if len(data) == 0 {
+		return []PX{}, nil
+	}
+	imgs, err := decodeIcon(data)
 	if err != nil {
-		log.Printf("Failed to read icon format %v", err)
-		return PX{}
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIcon, err)
+	}
+	if !isICO(data) {
+		imgs = withStandardSizes(imgs[0])
 	}
 
-	return PX{
-		img.Bounds().Dx(), img.Bounds().Dy(),
-		argbForImage(img),
+	pixmaps := make([]PX, len(imgs))
+	for i, img := range imgs {
+		pixmaps[i] = PX{
+			img.Bounds().Dx(), img.Bounds().Dy(),
+			argbForImage(img),
+		}
 	}
+	return pixmaps, nil
 }
 
 func argbForImage(img image.Image) []byte {
@@ -0,0 +1,718 @@
+//go:build (linux || freebsd || openbsd || netbsd) && !android
+
+package systray
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"image/color"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/prop"
+
+	"fyne.io/systray/internal/generated/menu"
+)
+
+func TestRunContextStartupFailure(t *testing.T) {
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", "unix:path=/nonexistent/bus")
+
+	exited := false
+	err := RunContext(context.Background(), WithOnExit(func() {
+		exited = true
+	}))
+	if err == nil {
+		t.Fatal("expected the failure to connect to DBus to be returned")
+	}
+	if !exited {
+		t.Error("expected onExit to be called after onReady")
+	}
+}
+
+func TestWithBusAddress(t *testing.T) {
+	address := startBus(t)
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatalf("failed to connect to the test bus: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go waitForName(t, conn, cancel)
+	err = RunContext(ctx, WithBusAddress(address))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if hasName(t, conn) {
+		t.Error("expected the name to be released once the tray quits")
+	}
+}
+
+func TestWithDBusConn(t *testing.T) {
+	conn, err := dbus.Connect(startBus(t))
+	if err != nil {
+		t.Fatalf("failed to connect to the test bus: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go waitForName(t, conn, cancel)
+	err = RunContext(ctx, WithDBusConn(conn))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !conn.Connected() {
+		t.Fatal("expected the connection of the application to stay open")
+	}
+	if hasName(t, conn) {
+		t.Error("expected the name to be released once the tray quits")
+	}
+}
+
+// startBus starts a dbus-daemon for the test and returns its address, the test is skipped
+// when dbus-daemon is not installed
+func startBus(t *testing.T) string {
+	t.Helper()
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon is not installed")
+	}
+	cmd := exec.Command(daemon, "--session", "--nofork", "--print-address")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("failed to start dbus-daemon: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	address, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		t.Fatalf("failed to read the address of dbus-daemon: %v", err)
+	}
+	return strings.TrimSpace(address)
+}
+
+// hasName returns whether the name of the default tray is owned on the bus of conn
+func hasName(t *testing.T, conn *dbus.Conn) bool {
+	name := fmt.Sprintf("org.kde.StatusNotifierItem-%d-1", os.Getpid())
+	var owned bool
+	err := conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, name).Store(&owned)
+	if err != nil {
+		t.Errorf("failed to look up %s: %v", name, err)
+	}
+	return owned
+}
+
+// waitForName calls quit once the default tray owns its name on the bus of conn
+func waitForName(t *testing.T, conn *dbus.Conn, quit func()) {
+	defer quit()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if hasName(t, conn) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("expected the tray to own its name on the bus")
+}
+
+// testBus is a dbus-daemon started for a test, serving a StatusNotifierWatcher and watching
+// the signals of the trays
+type testBus struct {
+	address string
+	conn    *dbus.Conn
+	signals chan *dbus.Signal
+	// registered receives the items registered with the watcher, as the sender and the
+	// service given
+	registered chan [2]string
+}
+
+// RegisterStatusNotifierItem is org.kde.StatusNotifierWatcher.RegisterStatusNotifierItem method.
+func (b *testBus) RegisterStatusNotifierItem(sender dbus.Sender, service string) *dbus.Error {
+	b.registered <- [2]string{string(sender), service}
+	return nil
+}
+
+// newTestBus starts a bus for the test, serving a StatusNotifierWatcher
+func newTestBus(t *testing.T) *testBus {
+	t.Helper()
+	b := &testBus{address: startBus(t), signals: make(chan *dbus.Signal, 100), registered: make(chan [2]string, 10)}
+	b.conn = b.connect(t)
+	err := b.conn.Export(b, "/StatusNotifierWatcher", "org.kde.StatusNotifierWatcher")
+	if err != nil {
+		t.Fatalf("failed to export the watcher: %v", err)
+	}
+	_, err = prop.Export(b.conn, "/StatusNotifierWatcher", prop.Map{
+		"org.kde.StatusNotifierWatcher": {
+			"IsStatusNotifierHostRegistered": {Value: true, Emit: prop.EmitTrue},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to export the watcher properties: %v", err)
+	}
+	if _, err := b.conn.RequestName("org.kde.StatusNotifierWatcher", dbus.NameFlagDoNotQueue); err != nil {
+		t.Fatalf("failed to request the watcher name: %v", err)
+	}
+	if err := b.conn.AddMatchSignal(dbus.WithMatchPathNamespace(path)); err != nil {
+		t.Fatalf("failed to watch signals: %v", err)
+	}
+	b.conn.Signal(b.signals)
+	return b
+}
+
+// connect returns a new connection to the bus, closed at the end of the test
+func (b *testBus) connect(t *testing.T) *dbus.Conn {
+	t.Helper()
+	conn, err := dbus.Connect(b.address)
+	if err != nil {
+		t.Fatalf("failed to connect to the test bus: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// runOnBus runs the tray with the options on a bus started for the test, see testBus.run.
+func runOnBus(t *testing.T, opts ...Option) *testBus {
+	t.Helper()
+	b := newTestBus(t)
+	b.run(t, opts...)
+	return b
+}
+
+// run runs the tray with the options on the bus and returns once the default tray registered
+// with the watcher and NewTray creates trays right away. The tray quits at the end of the test.
+func (b *testBus) run(t *testing.T, opts ...Option) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- RunContext(ctx, append([]Option{WithBusAddress(b.address)}, opts...)...)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("expected the tray to run, got %v", err)
+		}
+	})
+	b.nextRegistered(t)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		traysLock.Lock()
+		started := traysStarted
+		traysLock.Unlock()
+		if started {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the trays to be started")
+		}
+	}
+}
+
+// nextRegistered returns the sender and service of the next item registered with the watcher
+func (b *testBus) nextRegistered(t *testing.T) [2]string {
+	t.Helper()
+	select {
+	case r := <-b.registered:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected an item to register with the watcher")
+		return [2]string{}
+	}
+}
+
+// until returns the signal with the name, as interface.member, along with the signals
+// received before it
+func (b *testBus) until(t *testing.T, name string) (*dbus.Signal, []*dbus.Signal) {
+	t.Helper()
+	var before []*dbus.Signal
+	for {
+		select {
+		case s := <-b.signals:
+			if s.Name == name {
+				return s, before
+			}
+			before = append(before, s)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected the %s signal", name)
+			return nil, nil
+		}
+	}
+}
+
+// item returns the StatusNotifierItem of the tray
+func (b *testBus) item(tray *Tray) dbus.BusObject {
+	return b.conn.Object(tray.native.busName(), tray.native.itemPath())
+}
+
+// menu returns the dbusmenu of the tray
+func (b *testBus) menu(tray *Tray) dbus.BusObject {
+	return b.conn.Object(tray.native.busName(), tray.native.menuPath())
+}
+
+// property returns the value of the property of obj, named as interface.property
+func property(t *testing.T, obj dbus.BusObject, name string) interface{} {
+	t.Helper()
+	v, err := obj.GetProperty(name)
+	if err != nil {
+		t.Fatalf("failed to get %s: %v", name, err)
+	}
+	return v.Value()
+}
+
+// itemProperties returns the properties of the menu item with the ID from the layout of obj
+func itemProperties(t *testing.T, obj dbus.BusObject, id uint32) map[string]dbus.Variant {
+	t.Helper()
+	var props []struct {
+		V0 int32
+		V1 map[string]dbus.Variant
+	}
+	err := obj.Call("com.canonical.dbusmenu.GetGroupProperties", 0, []int32{int32(id)}, []string{}).Store(&props)
+	if err != nil {
+		t.Fatalf("failed to get the properties of item %d: %v", id, err)
+	}
+	if len(props) != 1 {
+		t.Fatalf("expected the properties of item %d", id)
+	}
+	return props[0].V1
+}
+
+func TestSetMenuSignals(t *testing.T) {
+	b := runOnBus(t)
+	icon := solidPNG(t, 16, color.White)
+
+	SetMenu(Menu{Items: []MenuEntry{{Title: "Open", Icon: icon}}})
+	b.until(t, "com.canonical.dbusmenu.LayoutUpdated")
+	id := defaultTray.declaredMenu[0].item.id
+
+	SetMenu(Menu{Items: []MenuEntry{{Title: "Open", Disabled: true}}})
+	SetTitle("done")
+	_, before := b.until(t, "org.kde.StatusNotifierItem.NewTitle")
+	removed := false
+	for _, s := range before {
+		if s.Name == "com.canonical.dbusmenu.LayoutUpdated" {
+			t.Error("property changes made by SetMenu must not emit LayoutUpdated")
+		}
+		if s.Name == "com.canonical.dbusmenu.ItemsPropertiesUpdated" {
+			var body menu.Dbusmenu_ItemsPropertiesUpdatedSignalBody
+			if err := dbus.Store(s.Body, &body.UpdatedProps, &body.RemovedProps); err != nil {
+				t.Fatalf("unexpected ItemsPropertiesUpdated body: %v", err)
+			}
+			for _, r := range body.RemovedProps {
+				removed = removed || r.V0 == int32(id) && len(r.V1) == 1 && r.V1[0] == "icon-data"
+			}
+		}
+	}
+	if !removed {
+		t.Error("expected the icon removed from the entry to be reported as a removed property")
+	}
+
+	props := itemProperties(t, b.menu(defaultTray), id)
+	if _, ok := props["icon-data"]; ok {
+		t.Error("expected the icon to be cleared")
+	}
+	if enabled := props["enabled"].Value(); enabled != false {
+		t.Errorf("expected the item to be disabled, got enabled %v", enabled)
+	}
+}
+
+func TestNewTrayOnBus(t *testing.T) {
+	b := runOnBus(t)
+	tray := NewTray()
+	defer tray.Remove()
+
+	registered := b.nextRegistered(t)
+	if registered[1] != string(tray.native.itemPath()) || tray.native.itemPath() == path {
+		t.Errorf("expected the tray to register its own path, got %q", registered[1])
+	}
+	if !tray.HostAvailable() {
+		t.Error("expected the host of the watcher to be available to the tray")
+	}
+
+	SetTitle("Default")
+	tray.SetTitle("Added")
+	if title := property(t, b.item(tray), "org.kde.StatusNotifierItem.Title"); title != "Added" {
+		t.Errorf("expected the title of the added tray, got %q", title)
+	}
+	if title := property(t, b.item(defaultTray), "org.kde.StatusNotifierItem.Title"); title != "Default" {
+		t.Errorf("expected the title of the default tray, got %q", title)
+	}
+
+	changed := make(chan bool, 1)
+	tray.SetOnHostChanged(func(available bool) { changed <- available })
+	if _, err := b.conn.ReleaseName("org.kde.StatusNotifierWatcher"); err != nil {
+		t.Fatalf("failed to release the watcher name: %v", err)
+	}
+	select {
+	case available := <-changed:
+		if available {
+			t.Error("expected the host to be unavailable without a watcher")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the tray to report that the watcher went away")
+	}
+}
+
+func TestWithDBusConnSharedByTrays(t *testing.T) {
+	b := newTestBus(t)
+	conn := b.connect(t)
+	b.run(t, WithDBusConn(conn))
+	tray := NewTray()
+	defer tray.Remove()
+
+	registered := b.nextRegistered(t)
+	if registered[0] != conn.Names()[0] {
+		t.Errorf("expected the added tray to use the connection given, got %s", registered[0])
+	}
+	tray.SetTitle("Added")
+	if title := property(t, b.item(tray), "org.kde.StatusNotifierItem.Title"); title != "Added" {
+		t.Errorf("expected the added tray to be served on the connection, got %q", title)
+	}
+
+	Hide()
+	if hasName(t, b.conn) {
+		t.Error("expected the name of the hidden tray to be released")
+	}
+	Show()
+	if registered := b.nextRegistered(t); registered[1] != defaultTray.native.busName() {
+		t.Errorf("expected the default tray to register its name again, got %q", registered[1])
+	}
+}
+
+func TestScroll(t *testing.T) {
+	type scroll struct {
+		delta       int
+		orientation Orientation
+	}
+	b := runOnBus(t)
+	scrolled := make(chan scroll, 1)
+	SetOnScroll(func(delta int, orientation Orientation) { scrolled <- scroll{delta, orientation} })
+	defer SetOnScroll(nil)
+
+	err := b.item(defaultTray).Call("org.kde.StatusNotifierItem.Scroll", 0, int32(-120), "Horizontal").Err
+	if err != nil {
+		t.Fatalf("Scroll failed: %v", err)
+	}
+	if got := <-scrolled; got != (scroll{-120, OrientationHorizontal}) {
+		t.Errorf("unexpected scroll %+v", got)
+	}
+}
+
+func TestSetStatusOnBus(t *testing.T) {
+	b := runOnBus(t)
+	defer SetStatus(StatusActive)
+
+	SetStatus(StatusNeedsAttention)
+	s, _ := b.until(t, "org.kde.StatusNotifierItem.NewStatus")
+	if len(s.Body) != 1 || s.Body[0] != "NeedsAttention" {
+		t.Errorf("unexpected NewStatus body %v", s.Body)
+	}
+	if status := property(t, b.item(defaultTray), "org.kde.StatusNotifierItem.Status"); status != "NeedsAttention" {
+		t.Errorf("expected the Status property to be NeedsAttention, got %q", status)
+	}
+}
+
+// pixmap returns the sizes of the images of the pixmap property of obj
+func pixmap(t *testing.T, obj dbus.BusObject, name string) []int {
+	t.Helper()
+	v, err := obj.GetProperty(name)
+	if err != nil {
+		t.Fatalf("failed to get %s: %v", name, err)
+	}
+	var pixels []PX
+	if err := v.Store(&pixels); err != nil {
+		t.Fatalf("unexpected %s value %v: %v", name, v, err)
+	}
+	sizes := make([]int, len(pixels))
+	for i, p := range pixels {
+		sizes[i] = p.W
+	}
+	return sizes
+}
+
+func TestSetAttentionIconOnBus(t *testing.T) {
+	b := runOnBus(t)
+
+	if err := TrySetAttentionIcon(solidPNG(t, 16, color.White)); err != nil {
+		t.Fatalf("TrySetAttentionIcon failed: %v", err)
+	}
+	b.until(t, "org.kde.StatusNotifierItem.NewAttentionIcon")
+	if sizes := pixmap(t, b.item(defaultTray), "org.kde.StatusNotifierItem.AttentionIconPixmap"); len(sizes) != 1 || sizes[0] != 16 {
+		t.Errorf("expected a 16px attention icon, got sizes %v", sizes)
+	}
+}
+
+func TestSetIconNamesOnBus(t *testing.T) {
+	b := runOnBus(t)
+	defer SetAttentionIconName("")
+	defer SetOverlayIconName("")
+
+	SetAttentionIconName("dialog-warning")
+	b.until(t, "org.kde.StatusNotifierItem.NewAttentionIcon")
+	if name := property(t, b.item(defaultTray), "org.kde.StatusNotifierItem.AttentionIconName"); name != "dialog-warning" {
+		t.Errorf("expected the AttentionIconName property to be dialog-warning, got %q", name)
+	}
+
+	SetOverlayIconName("emblem-important")
+	b.until(t, "org.kde.StatusNotifierItem.NewOverlayIcon")
+	if name := property(t, b.item(defaultTray), "org.kde.StatusNotifierItem.OverlayIconName"); name != "emblem-important" {
+		t.Errorf("expected the OverlayIconName property to be emblem-important, got %q", name)
+	}
+}
+
+func TestSetOverlayIconOnBus(t *testing.T) {
+	b := runOnBus(t)
+	defer ClearOverlayIcon()
+
+	if err := TrySetOverlayIcon(solidPNG(t, 8, color.White)); err != nil {
+		t.Fatalf("TrySetOverlayIcon failed: %v", err)
+	}
+	b.until(t, "org.kde.StatusNotifierItem.NewOverlayIcon")
+	if sizes := pixmap(t, b.item(defaultTray), "org.kde.StatusNotifierItem.OverlayIconPixmap"); len(sizes) != 1 || sizes[0] != 8 {
+		t.Errorf("expected an 8px overlay icon, got sizes %v", sizes)
+	}
+
+	ClearOverlayIcon()
+	b.until(t, "org.kde.StatusNotifierItem.NewOverlayIcon")
+	if sizes := pixmap(t, b.item(defaultTray), "org.kde.StatusNotifierItem.OverlayIconPixmap"); len(sizes) != 0 {
+		t.Errorf("expected the overlay icon to be cleared, got sizes %v", sizes)
+	}
+}
+
+func TestSetIconNameOnBus(t *testing.T) {
+	b := runOnBus(t)
+	defer ResetMenu()
+	defer SetIconThemePath("")
+	defer SetIconName("")
+
+	SetIconName("mail-unread")
+	b.until(t, "org.kde.StatusNotifierItem.NewIcon")
+	if name := property(t, b.item(defaultTray), "org.kde.StatusNotifierItem.IconName"); name != "mail-unread" {
+		t.Errorf("expected the IconName property to be mail-unread, got %q", name)
+	}
+
+	dir := t.TempDir()
+	SetIconThemePath(dir)
+	s, _ := b.until(t, "org.kde.StatusNotifierItem.NewIconThemePath")
+	if len(s.Body) != 1 || s.Body[0] != dir {
+		t.Errorf("unexpected NewIconThemePath body %v", s.Body)
+	}
+	if path := property(t, b.item(defaultTray), "org.kde.StatusNotifierItem.IconThemePath"); path != dir {
+		t.Errorf("expected the IconThemePath property to be %s, got %q", dir, path)
+	}
+	paths, ok := property(t, b.menu(defaultTray), "com.canonical.dbusmenu.IconThemePath").([]string)
+	if !ok || len(paths) != 1 || paths[0] != dir {
+		t.Errorf("expected the menu to search %s, got %v", dir, paths)
+	}
+
+	item := AddMenuItem("Inbox", "")
+	item.SetIconName("mail-inbox")
+	if name := itemProperties(t, b.menu(defaultTray), item.id)["icon-name"].Value(); name != "mail-inbox" {
+		t.Errorf("expected the icon-name of the item to be mail-inbox, got %v", name)
+	}
+}
+
+func TestTappedAtOnBus(t *testing.T) {
+	b := runOnBus(t)
+	defer SetOnTapped(nil)
+	defer SetOnTappedAt(nil)
+	defer SetOnMiddleTapped(nil)
+	tapped := make(chan [2]int, 1)
+	middle := make(chan struct{}, 1)
+	SetOnTapped(func() { t.Error("SetOnTappedAt must take precedence over SetOnTapped") })
+	SetOnTappedAt(func(x, y int) { tapped <- [2]int{x, y} })
+	SetOnMiddleTapped(func() { middle <- struct{}{} })
+
+	if err := b.item(defaultTray).Call("org.kde.StatusNotifierItem.Activate", 0, int32(12), int32(34)).Err; err != nil {
+		t.Fatalf("Activate failed: %v", err)
+	}
+	if got := <-tapped; got != [2]int{12, 34} {
+		t.Errorf("expected the position of the click, got %v", got)
+	}
+	if err := b.item(defaultTray).Call("org.kde.StatusNotifierItem.SecondaryActivate", 0, int32(1), int32(2)).Err; err != nil {
+		t.Fatalf("SecondaryActivate failed: %v", err)
+	}
+	<-middle
+
+	defer SetOnMiddleTappedAt(nil)
+	SetOnMiddleTappedAt(func(x, y int) { tapped <- [2]int{x, y} })
+	if err := b.item(defaultTray).Call("org.kde.StatusNotifierItem.SecondaryActivate", 0, int32(56), int32(78)).Err; err != nil {
+		t.Fatalf("SecondaryActivate failed: %v", err)
+	}
+	if got := <-tapped; got != [2]int{56, 78} {
+		t.Errorf("expected the position of the middle click, got %v", got)
+	}
+}
+
+func TestMenuItemTooltipOnBus(t *testing.T) {
+	b := runOnBus(t)
+	defer ResetMenu()
+
+	item := AddMenuItem("Sync", "Synchronize now")
+	props := itemProperties(t, b.menu(defaultTray), item.id)
+	for _, name := range []string{"tooltip", "accessible-desc"} {
+		if tooltip := props[name].Value(); tooltip != "Synchronize now" {
+			t.Errorf("expected the %s of the item to be the tooltip, got %v", name, tooltip)
+		}
+	}
+
+	item.SetTooltip("Last synchronized today")
+	s, _ := b.until(t, "com.canonical.dbusmenu.ItemsPropertiesUpdated")
+	var body menu.Dbusmenu_ItemsPropertiesUpdatedSignalBody
+	if err := dbus.Store(s.Body, &body.UpdatedProps, &body.RemovedProps); err != nil {
+		t.Fatalf("unexpected ItemsPropertiesUpdated body: %v", err)
+	}
+	updated := false
+	for _, u := range body.UpdatedProps {
+		updated = updated || u.V0 == int32(item.id) && u.V1["tooltip"].Value() == "Last synchronized today"
+	}
+	if !updated {
+		t.Errorf("expected the new tooltip to be reported, got %v", body.UpdatedProps)
+	}
+}
+
+func TestMenuItemShortcutOnBus(t *testing.T) {
+	b := runOnBus(t)
+	defer ResetMenu()
+
+	item := AddMenuItem("Quit", "")
+	if shortcut, ok := itemProperties(t, b.menu(defaultTray), item.id)["shortcut"].Value().([][]string); !ok || len(shortcut) != 0 {
+		t.Errorf("expected no shortcut, got %v", shortcut)
+	}
+
+	item.SetShortcut(ModifierControl|ModifierShift, "Q")
+	shortcut, _ := itemProperties(t, b.menu(defaultTray), item.id)["shortcut"].Value().([][]string)
+	if len(shortcut) != 1 || strings.Join(shortcut[0], "+") != "Control+Shift+Q" {
+		t.Errorf("expected the shortcut Control+Shift+Q, got %v", shortcut)
+	}
+}
+
+func TestSetIconWithoutHost(t *testing.T) {
+	b := runOnBus(t)
+	defer defaultTray.setHostAvailable(HostAvailable())
+	defaultTray.setHostAvailable(false)
+
+	if err := TrySetIcon(solidPNG(t, 16, color.White)); err != nil {
+		t.Errorf("expected the icon to be set without a host, got %v", err)
+	}
+	b.until(t, "org.kde.StatusNotifierItem.NewIcon")
+	if sizes := pixmap(t, b.item(defaultTray), "org.kde.StatusNotifierItem.IconPixmap"); len(sizes) == 0 {
+		t.Error("expected the icon to be exported for the next host")
+	}
+
+	if err := TrySetIcon(nil); err != nil {
+		t.Errorf("expected empty bytes to remove the icon, got %v", err)
+	}
+	b.until(t, "org.kde.StatusNotifierItem.NewIcon")
+	if sizes := pixmap(t, b.item(defaultTray), "org.kde.StatusNotifierItem.IconPixmap"); len(sizes) != 0 {
+		t.Errorf("expected the icon to be removed, got sizes %v", sizes)
+	}
+}
+
+func TestPixelsForDownscales(t *testing.T) {
+	single, err := encodeICO(map[int][]byte{32: solidPNG(t, 32, color.White)})
+	if err != nil {
+		t.Fatalf("encodeICO failed: %s", err)
+	}
+	for _, c := range []struct {
+		name  string
+		data  []byte
+		count int
+	}{
+		{"png", solidPNG(t, 32, color.White), 4},
+		{"ico", single, 1},
+	} {
+		pixels, err := pixelsFor(c.data)
+		if err != nil {
+			t.Fatalf("pixelsFor failed on the %s: %s", c.name, err)
+		}
+		if len(pixels) != c.count {
+			t.Errorf("expected %d pixmaps for the %s, got %d", c.count, c.name, len(pixels))
+		}
+	}
+}
+
+func TestMenuBatchOnBus(t *testing.T) {
+	b := runOnBus(t)
+	defer ResetMenu()
+	defer SetTitle("")
+
+	reconciled := AddMenuItem("Reconciled", "")
+	other := AddMenuItem("Other", "")
+	SetTitle("before")
+	b.until(t, "org.kde.StatusNotifierItem.NewTitle")
+
+	nativeBackend{}.beginMenuBatch(defaultTray)
+	nativeBackend{}.batchMenuItem(reconciled)
+	reconciled.SetTitle("Renamed")
+	// changed meanwhile by another goroutine, its layout change must not be lost
+	other.Hide()
+	nativeBackend{}.endMenuBatch(defaultTray)
+	SetTitle("after")
+	_, before := b.until(t, "org.kde.StatusNotifierItem.NewTitle")
+
+	var updates, layouts int
+	updated := map[int32]bool{}
+	for _, s := range before {
+		switch s.Name {
+		case "com.canonical.dbusmenu.LayoutUpdated":
+			layouts++
+		case "com.canonical.dbusmenu.ItemsPropertiesUpdated":
+			updates++
+			var body menu.Dbusmenu_ItemsPropertiesUpdatedSignalBody
+			if err := dbus.Store(s.Body, &body.UpdatedProps, &body.RemovedProps); err != nil {
+				t.Fatalf("unexpected ItemsPropertiesUpdated body: %v", err)
+			}
+			for _, u := range body.UpdatedProps {
+				updated[u.V0] = true
+			}
+		}
+	}
+	if updates != 1 || !updated[int32(reconciled.id)] || !updated[int32(other.id)] {
+		t.Errorf("expected both items in a single ItemsPropertiesUpdated, got %d signals for %v", updates, updated)
+	}
+	if layouts != 1 {
+		t.Errorf("expected a single LayoutUpdated for the item changed outside the batch, got %d", layouts)
+	}
+}
+
+func TestRadioGroupOnBus(t *testing.T) {
+	b := runOnBus(t)
+	defer ResetMenu()
+	defer SetTitle("")
+
+	first := AddMenuItemRadio("profile", "First", "")
+	second := AddMenuItemRadio("profile", "Second", "")
+	first.Check()
+	SetTitle("before")
+	b.until(t, "org.kde.StatusNotifierItem.NewTitle")
+
+	second.Check()
+	SetTitle("after")
+	_, before := b.until(t, "org.kde.StatusNotifierItem.NewTitle")
+	var updates, layouts int
+	for _, s := range before {
+		switch s.Name {
+		case "com.canonical.dbusmenu.LayoutUpdated":
+			layouts++
+		case "com.canonical.dbusmenu.ItemsPropertiesUpdated":
+			updates++
+		}
+	}
+	if updates != 1 || layouts != 1 {
+		t.Errorf("expected the group switch to be signalled once, got %d ItemsPropertiesUpdated and %d LayoutUpdated", updates, layouts)
+	}
+	for item, state := range map[*MenuItem]int32{first: 0, second: 1} {
+		if got := itemProperties(t, b.menu(defaultTray), item.id)["toggle-state"].Value(); got != state {
+			t.Errorf("expected the toggle-state of %s to be %d, got %v", item, state, got)
+		}
+	}
+}
@@ -5,10 +5,8 @@ package systray
 import (
 	"crypto/md5"
 	"encoding/hex"
-	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
 	"sort"
@@ -24,30 +22,38 @@ import (
 
 var (
 	g32                     = windows.NewLazySystemDLL("Gdi32.dll")
+	pCreateBitmap           = g32.NewProc("CreateBitmap")
 	pCreateCompatibleBitmap = g32.NewProc("CreateCompatibleBitmap")
 	pCreateCompatibleDC     = g32.NewProc("CreateCompatibleDC")
 	pCreateDIBSection       = g32.NewProc("CreateDIBSection")
 	pDeleteDC               = g32.NewProc("DeleteDC")
+	pDeleteObject           = g32.NewProc("DeleteObject")
 	pSelectObject           = g32.NewProc("SelectObject")
 
 	k32              = windows.NewLazySystemDLL("Kernel32.dll")
 	pGetModuleHandle = k32.NewProc("GetModuleHandleW")
 
-	s32              = windows.NewLazySystemDLL("Shell32.dll")
-	pShellNotifyIcon = s32.NewProc("Shell_NotifyIconW")
+	s32                     = windows.NewLazySystemDLL("Shell32.dll")
+	pShellNotifyIcon        = s32.NewProc("Shell_NotifyIconW")
+	pShellNotifyIconGetRect = s32.NewProc("Shell_NotifyIconGetRect")
 
 	u32                    = windows.NewLazySystemDLL("User32.dll")
+	pCallNextHookEx        = u32.NewProc("CallNextHookEx")
+	pCreateIconIndirect    = u32.NewProc("CreateIconIndirect")
 	pCreateMenu            = u32.NewProc("CreateMenu")
 	pCreatePopupMenu       = u32.NewProc("CreatePopupMenu")
 	pCreateWindowEx        = u32.NewProc("CreateWindowExW")
 	pDefWindowProc         = u32.NewProc("DefWindowProcW")
 	pDeleteMenu            = u32.NewProc("DeleteMenu")
+	pDestroyIcon           = u32.NewProc("DestroyIcon")
 	pDestroyMenu           = u32.NewProc("DestroyMenu")
 	pRemoveMenu            = u32.NewProc("RemoveMenu")
 	pDestroyWindow         = u32.NewProc("DestroyWindow")
 	pDispatchMessage       = u32.NewProc("DispatchMessageW")
 	pDrawIconEx            = u32.NewProc("DrawIconEx")
 	pGetCursorPos          = u32.NewProc("GetCursorPos")
+	pGetKeyState           = u32.NewProc("GetKeyState")
+	pGetMessageTime        = u32.NewProc("GetMessageTime")
 	pGetDC                 = u32.NewProc("GetDC")
 	pGetMessage            = u32.NewProc("GetMessageW")
 	pGetSystemMetrics      = u32.NewProc("GetSystemMetrics")
@@ -63,16 +69,27 @@ var (
 	pSetForegroundWindow   = u32.NewProc("SetForegroundWindow")
 	pSetMenuInfo           = u32.NewProc("SetMenuInfo")
 	pSetMenuItemInfo       = u32.NewProc("SetMenuItemInfoW")
+	pSetWindowsHookEx      = u32.NewProc("SetWindowsHookExW")
 	pShowWindow            = u32.NewProc("ShowWindow")
 	pTrackPopupMenu        = u32.NewProc("TrackPopupMenu")
 	pTranslateMessage      = u32.NewProc("TranslateMessage")
+	pUnhookWindowsHookEx   = u32.NewProc("UnhookWindowsHookEx")
 	pUnregisterClass       = u32.NewProc("UnregisterClassW")
 	pUpdateWindow          = u32.NewProc("UpdateWindow")
 
 	// ErrTrayNotReadyYet is returned by functions when they are called before the tray has been initialized.
-	ErrTrayNotReadyYet = errors.New("tray not ready yet")
+	// It is the same as ErrNotReady.
+	ErrTrayNotReadyYet = ErrNotReady
 )
 
+// Contains information about an icon, used to create one from bitmaps.
+// https://learn.microsoft.com/en-us/windows/win32/api/winuser/ns-winuser-iconinfo
+type iconInfo struct {
+	Icon                    int32
+	XHotspot, YHotspot      uint32
+	MaskBitmap, ColorBitmap windows.Handle
+}
+
 // Contains window class information.
 // It is used with the RegisterClassEx and GetClassInfoEx functions.
 // https://msdn.microsoft.com/en-us/library/ms633577.aspx
@@ -182,6 +199,30 @@ type point struct {
 	X, Y int32
 }
 
+// The RECT structure defines a rectangle by the coordinates of its upper-left and lower-right corners.
+// https://learn.microsoft.com/en-us/windows/win32/api/windef/ns-windef-rect
+type rect struct {
+	Left, Top, Right, Bottom int32
+}
+
+// Identifies the icon whose bounding rectangle is retrieved by Shell_NotifyIconGetRect.
+// https://learn.microsoft.com/en-us/windows/win32/api/shellapi/ns-shellapi-notifyiconidentifier
+type notifyIconIdentifier struct {
+	Size     uint32
+	Wnd      windows.Handle
+	ID       uint32
+	GuidItem windows.GUID
+}
+
+// Contains information about a low-level mouse input event.
+// https://learn.microsoft.com/en-us/windows/win32/api/winuser/ns-winuser-msllhookstruct
+type msllHookStruct struct {
+	Pt          point
+	MouseData   uint32
+	Flags, Time uint32
+	ExtraInfo   uintptr
+}
+
 // The BITMAPINFO structure defines the dimensions and color information for a DIB.
 // https://learn.microsoft.com/en-us/windows/win32/api/wingdi/ns-wingdi-bitmapinfo
 type bitmapInfo struct {
@@ -207,7 +248,10 @@ type bitmapInfoHeader struct {
 }
 
 // Contains information about loaded resources
-type winTray struct {
+type nativeTray struct {
+	// owner is the tray shown by the icon
+	owner *Tray
+
 	instance,
 	icon,
 	cursor,
@@ -232,27 +276,53 @@ type winTray struct {
 
 	nid   *notifyIconData
 	muNID sync.RWMutex
-	wcex  *wndClassEx
+	// hidden is true while the icon is removed from the notification area, changes
+	// are then kept in nid until it is added again. It is protected by muNID.
+	hidden bool
+	// trayIcon, attentionIcon and overlayIcon are the icons set by the
+	// application, which one is shown depends on status. The overlay is
+	// composited into compositeIcon. They are protected by muNID.
+	trayIcon, attentionIcon, overlayIcon, compositeIcon windows.Handle
+	status                                              Status
+	wcex                                                *wndClassEx
 
 	wmSystrayMessage,
+	wmSystrayScroll,
 	wmTaskbarCreated uint32
 
 	initialized atomic.Bool
 }
 
 // isReady checks if the tray as already been initialized. It is not goroutine safe with in regard to the initialization function, but prevents a panic when functions are called too early.
-func (t *winTray) isReady() bool {
+func (t *nativeTray) isReady() bool {
 	return t.initialized.Load()
 }
 
 // Loads an image from file and shows it in tray.
 // Shell_NotifyIcon: https://msdn.microsoft.com/en-us/library/windows/desktop/bb762159(v=vs.85).aspx
-func (t *winTray) setIcon(src string) error {
-	if !wt.isReady() {
+func (t *nativeTray) setIcon(src string) error {
+	if !t.isReady() {
 		return ErrTrayNotReadyYet
 	}
 
-	const NIF_ICON = 0x00000002
+	h, err := t.loadIconFrom(src)
+	if err != nil {
+		return err
+	}
+
+	t.muNID.Lock()
+	defer t.muNID.Unlock()
+	t.trayIcon = h
+
+	return t.updateIcon()
+}
+
+// Loads an image from file to be shown in tray instead of the regular icon while the
+// status is StatusNeedsAttention.
+func (t *nativeTray) setAttentionIcon(src string) error {
+	if !t.isReady() {
+		return ErrTrayNotReadyYet
+	}
 
 	h, err := t.loadIconFrom(src)
 	if err != nil {
@@ -261,17 +331,84 @@ func (t *winTray) setIcon(src string) error {
 
 	t.muNID.Lock()
 	defer t.muNID.Unlock()
-	t.nid.Icon = h
-	t.nid.Flags |= NIF_ICON
+	t.attentionIcon = h
+
+	return t.updateIcon()
+}
+
+// Loads an image from file to be drawn over the bottom right quarter of the tray icon.
+// An empty src removes the overlay.
+func (t *nativeTray) setOverlayIcon(src string) error {
+	if !t.isReady() {
+		return ErrTrayNotReadyYet
+	}
+
+	var h windows.Handle
+	if src != "" {
+		var err error
+		h, err = t.loadIconFrom(src)
+		if err != nil {
+			return err
+		}
+	}
+
+	t.muNID.Lock()
+	defer t.muNID.Unlock()
+	t.overlayIcon = h
+
+	return t.updateIcon()
+}
+
+// updateIcon shows the icon matching the current status, with the overlay if any.
+// muNID must be held.
+// Shell_NotifyIcon: https://msdn.microsoft.com/en-us/library/windows/desktop/bb762159(v=vs.85).aspx
+func (t *nativeTray) updateIcon() error {
+	const NIF_ICON = 0x00000002
+
+	h := t.trayIcon
+	if t.status == StatusNeedsAttention && t.attentionIcon != 0 {
+		h = t.attentionIcon
+	}
+	// the previous composite icon is destroyed once the shell has been given the new icon
+	previous := t.compositeIcon
+	switch {
+	case h != 0 && t.overlayIcon != 0:
+		composite, err := compositeIcons(h, t.overlayIcon)
+		if err != nil {
+			return err
+		}
+		t.compositeIcon = composite
+		h = composite
+	case h != 0:
+		t.compositeIcon = 0
+	}
+	if previous != t.compositeIcon {
+		defer destroyIcon(previous)
+	}
+	if h != 0 {
+		t.nid.Icon = h
+		t.nid.Flags |= NIF_ICON
+	}
 	t.nid.Size = uint32(unsafe.Sizeof(*t.nid))
 
+	if t.hidden {
+		return nil
+	}
 	return t.nid.modify()
 }
 
+// destroyIcon releases an icon created by the package, such as compositeIcon, nothing happens
+// for 0
+func destroyIcon(h windows.Handle) {
+	if h != 0 {
+		pDestroyIcon.Call(uintptr(h))
+	}
+}
+
 // Sets tooltip on icon.
 // Shell_NotifyIcon: https://msdn.microsoft.com/en-us/library/windows/desktop/bb762159(v=vs.85).aspx
-func (t *winTray) setTooltip(src string) error {
-	if !wt.isReady() {
+func (t *nativeTray) setTooltip(src string) error {
+	if !t.isReady() {
 		return ErrTrayNotReadyYet
 	}
 
@@ -287,28 +424,107 @@ func (t *winTray) setTooltip(src string) error {
 	t.nid.Flags |= NIF_TIP
 	t.nid.Size = uint32(unsafe.Sizeof(*t.nid))
 
+	if t.hidden {
+		return nil
+	}
 	return t.nid.modify()
 }
 
-var wt = winTray{}
+// Shows or hides the icon depending on the status, Windows has no notion of an icon
+// needing attention so it is shown like an active one, using the attention icon if set.
+// The status set before the icon is added is applied by addIcon.
+// Shell_NotifyIcon: https://msdn.microsoft.com/en-us/library/windows/desktop/bb762159(v=vs.85).aspx
+func (t *nativeTray) setStatus(status Status) error {
+	t.muNID.Lock()
+	defer t.muNID.Unlock()
+	t.status = status
+	if t.nid == nil {
+		return nil
+	}
+	t.applyStatus()
+
+	return t.updateIcon()
+}
+
+// applyStatus sets the state of nid matching status. muNID must be held.
+func (t *nativeTray) applyStatus() {
+	const NIF_STATE = 0x00000008
+	const NIS_HIDDEN = 0x00000001
+
+	t.nid.State = 0
+	if t.status == StatusPassive {
+		t.nid.State = NIS_HIDDEN
+	}
+	t.nid.StateMask = NIS_HIDDEN
+	t.nid.Flags |= NIF_STATE
+}
+
+func newNativeTray(owner *Tray) *nativeTray {
+	return &nativeTray{owner: owner}
+}
+
+// trayOfMenu returns the tray showing the menu along with the ID of the menu item owning it,
+// 0 for the top level menu
+func trayOfMenu(menu windows.Handle) (*nativeTray, uint32, bool) {
+	for _, t := range currentTrays() {
+		if menuItemId, ok := t.native.menuItemOf(menu); ok {
+			return t.native, menuItemId, true
+		}
+	}
+	return nil, 0, false
+}
+
+// trayOfIcon returns the tray whose notification area icon has the ID
+func trayOfIcon(iconId uint32) *Tray {
+	for _, t := range currentTrays() {
+		if t.native.iconId() == iconId {
+			return t
+		}
+	}
+	return nil
+}
+
+// iconId returns the ID of the notification area icon of the tray, they all share the window
+// of the default tray.
+func (t *nativeTray) iconId() uint32 {
+	return 99 + t.owner.id
+}
 
 // WindowProc callback function that processes messages sent to a window.
 // https://msdn.microsoft.com/en-us/library/windows/desktop/ms633573(v=vs.85).aspx
-func (t *winTray) wndProc(hWnd windows.Handle, message uint32, wParam, lParam uintptr) (lResult uintptr) {
+func (t *nativeTray) wndProc(hWnd windows.Handle, message uint32, wParam, lParam uintptr) (lResult uintptr) {
 	const (
-		WM_RBUTTONUP  = 0x0205
-		WM_LBUTTONUP  = 0x0202
-		WM_COMMAND    = 0x0111
-		WM_ENDSESSION = 0x0016
-		WM_CLOSE      = 0x0010
-		WM_DESTROY    = 0x0002
+		WM_RBUTTONUP       = 0x0205
+		WM_LBUTTONUP       = 0x0202
+		WM_MBUTTONUP       = 0x0208
+		WM_MOUSEMOVE       = 0x0200
+		WM_COMMAND         = 0x0111
+		WM_INITMENUPOPUP   = 0x0117
+		WM_UNINITMENUPOPUP = 0x0125
+		WM_MENUSELECT      = 0x011F
+		WM_ENDSESSION      = 0x0016
+		WM_CLOSE           = 0x0010
+		WM_DESTROY         = 0x0002
 	)
 	switch message {
 	case WM_COMMAND:
 		menuItemId := int32(wParam)
 		// https://docs.microsoft.com/en-us/windows/win32/menurc/wm-command#menus
 		if menuItemId != -1 {
-			systrayMenuItemSelected(uint32(wParam))
+			systrayMenuEvent(t.owner, uint32(wParam), currentMenuEvent(MenuEventClicked))
+		}
+	case WM_INITMENUPOPUP:
+		if owner, menuItemId, ok := trayOfMenu(windows.Handle(wParam)); ok {
+			systrayMenuEvent(owner.owner, menuItemId, currentMenuEvent(MenuEventOpened))
+			if menuItemId != 0 {
+				systrayMenuAboutToShow(menuItemId)
+			}
+		}
+	case WM_MENUSELECT:
+		menuSelected(t.owner, wParam, lParam)
+	case WM_UNINITMENUPOPUP:
+		if owner, menuItemId, ok := trayOfMenu(windows.Handle(wParam)); ok {
+			systrayMenuEvent(owner.owner, menuItemId, currentMenuEvent(MenuEventClosed))
 		}
 	case WM_CLOSE:
 		pDestroyWindow.Call(uintptr(t.window))
@@ -316,25 +532,44 @@ func (t *winTray) wndProc(hWnd windows.Handle, message uint32, wParam, lParam ui
 	case WM_DESTROY:
 		// same as WM_ENDSESSION, but throws 0 exit code after all
 		defer pPostQuitMessage.Call(uintptr(int32(0)))
+		defer stopWheelHook()
+		// forget the trays so that Run can be called again
+		defer stopTrays()
 		fallthrough
 	case WM_ENDSESSION:
-		t.muNID.Lock()
-		if t.nid != nil {
-			t.nid.delete()
-		}
-		t.muNID.Unlock()
+		deleteIcons()
 		runSystrayExit()
 	case t.wmSystrayMessage:
+		// the icons of all trays notify this window, wParam holds the ID of the icon
+		owner := trayOfIcon(uint32(wParam))
+		if owner == nil {
+			break
+		}
 		switch lParam {
 		case WM_LBUTTONUP:
-			systrayLeftClick()
+			systrayLeftClick(owner)
 		case WM_RBUTTONUP:
-			systrayRightClick()
+			systrayRightClick(owner)
+		case WM_MBUTTONUP:
+			systrayMiddleClick(owner)
+		case WM_MOUSEMOVE:
+			startWheelHook(owner)
+		}
+	case t.wmSystrayScroll:
+		// posted by the wheel hook, wParam holds the ID of the icon
+		if owner := trayOfIcon(uint32(wParam)); owner != nil {
+			systrayScroll(owner, lParam)
 		}
 	case t.wmTaskbarCreated: // on explorer.exe restarts
-		t.muNID.Lock()
-		t.nid.add()
-		t.muNID.Unlock()
+		for _, tray := range currentTrays() {
+			n := tray.native
+			n.muNID.Lock()
+			if n.nid != nil && !n.hidden {
+				err := n.nid.add()
+				tray.setHostAvailable(err == nil)
+			}
+			n.muNID.Unlock()
+		}
 	default:
 		// Calls the default window procedure to provide default processing for any window messages that an application does not process.
 		// https://msdn.microsoft.com/en-us/library/windows/desktop/ms633572(v=vs.85).aspx
@@ -348,7 +583,7 @@ func (t *winTray) wndProc(hWnd windows.Handle, message uint32, wParam, lParam ui
 	return
 }
 
-func (t *winTray) initInstance() error {
+func (t *nativeTray) initInstance() error {
 	const IDI_APPLICATION = 32512
 	const IDC_ARROW = 32512 // Standard arrow
 	// https://msdn.microsoft.com/en-us/library/windows/desktop/ms633548(v=vs.85).aspx
@@ -370,7 +605,6 @@ func (t *winTray) initInstance() error {
 		CS_HREDRAW = 0x0002
 		CS_VREDRAW = 0x0001
 	)
-	const NIF_MESSAGE = 0x00000001
 
 	// https://msdn.microsoft.com/en-us/library/windows/desktop/ms644931(v=vs.85).aspx
 	const WM_USER = 0x0400
@@ -381,10 +615,8 @@ func (t *winTray) initInstance() error {
 	)
 
 	t.wmSystrayMessage = WM_USER + 1
-	t.visibleItems = make(map[uint32][]uint32)
-	t.menus = make(map[uint32]windows.Handle)
-	t.menuOf = make(map[uint32]windows.Handle)
-	t.menuItemIcons = make(map[uint32]windows.Handle)
+	t.wmSystrayScroll = WM_USER + 2
+	t.initMenus()
 
 	taskbarEventNamePtr, _ := windows.UTF16PtrFromString("TaskbarCreated")
 	// https://msdn.microsoft.com/en-us/library/windows/desktop/ms644947
@@ -467,20 +699,117 @@ func (t *winTray) initInstance() error {
 		uintptr(t.window),
 	)
 
+	return t.addIcon()
+}
+
+func (t *nativeTray) initMenus() {
+	t.visibleItems = make(map[uint32][]uint32)
+	t.menus = make(map[uint32]windows.Handle)
+	t.menuOf = make(map[uint32]windows.Handle)
+	t.menuItemIcons = make(map[uint32]windows.Handle)
+}
+
+// addIcon adds the icon of the tray to the notification area, notifying the window of the tray,
+// unless the tray is hidden
+func (t *nativeTray) addIcon() error {
+	const NIF_MESSAGE = 0x00000001
+
 	t.muNID.Lock()
 	defer t.muNID.Unlock()
 	t.nid = &notifyIconData{
 		Wnd:             windows.Handle(t.window),
-		ID:              100,
+		ID:              t.iconId(),
 		Flags:           NIF_MESSAGE,
 		CallbackMessage: t.wmSystrayMessage,
 	}
 	t.nid.Size = uint32(unsafe.Sizeof(*t.nid))
+	if t.status != StatusActive {
+		t.applyStatus()
+	}
+
+	t.hidden = t.owner.hidden.Load()
+	if t.hidden {
+		return nil
+	}
+	err := t.nid.add()
+	t.owner.setHostAvailable(err == nil)
+	return err
+}
+
+// create adds the icon of a tray created by NewTray, sharing the window of the default tray
+func (t *Tray) create() error {
+	n, d := t.native, defaultTray.native
+	n.instance, n.icon, n.cursor, n.window = d.instance, d.icon, d.cursor, d.window
+	n.wmSystrayMessage, n.wmSystrayScroll, n.wmTaskbarCreated = d.wmSystrayMessage, d.wmSystrayScroll, d.wmTaskbarCreated
+	n.loadedImages = make(map[string]windows.Handle)
+	n.initMenus()
+	if err := n.createMenu(); err != nil {
+		return err
+	}
+	if err := n.addIcon(); err != nil {
+		return err
+	}
+	n.initialized.Store(true)
+	return nil
+}
+
+// destroy removes the icon of the tray from the notification area along with its menu
+func (t *Tray) destroy() error {
+	n := t.native
+	n.initialized.Store(false)
+	forgetWheelTray(t)
+	n.muMenus.RLock()
+	pDestroyMenu.Call(uintptr(n.menus[0]))
+	n.muMenus.RUnlock()
+
+	n.muNID.Lock()
+	defer n.muNID.Unlock()
+	defer func() {
+		destroyIcon(n.compositeIcon)
+		n.compositeIcon = 0
+	}()
+	if n.nid == nil || n.hidden {
+		n.nid = nil
+		return nil
+	}
+	err := n.nid.delete()
+	n.nid = nil
+	return err
+}
 
-	return t.nid.add()
+// setVisible adds the icon of the tray to the notification area again or removes it,
+// the changes made meanwhile being applied once it is back
+func (t *Tray) setVisible(visible bool) error {
+	n := t.native
+	n.muNID.Lock()
+	defer n.muNID.Unlock()
+	if n.hidden == !visible {
+		return nil
+	}
+	n.hidden = !visible
+	if n.nid == nil {
+		return nil
+	}
+	if visible {
+		return n.nid.add()
+	}
+	return n.nid.delete()
 }
 
-func (t *winTray) createMenu() error {
+// deleteIcons removes the icons of all trays from the notification area
+func deleteIcons() {
+	for _, t := range currentTrays() {
+		n := t.native
+		n.muNID.Lock()
+		if n.nid != nil && !n.hidden {
+			n.nid.delete()
+			n.hidden = true
+		}
+		n.muNID.Unlock()
+	}
+}
+
+func (t *nativeTray) createMenu() error {
 	const MIM_APPLYTOSUBMENUS = 0x80000000 // Settings apply to the menu and all of its submenus
 
 	menuHandle, _, err := pCreatePopupMenu.Call()
@@ -510,7 +839,7 @@ func (t *winTray) createMenu() error {
 	return nil
 }
 
-func (t *winTray) convertToSubMenu(menuItemId uint32) (windows.Handle, error) {
+func (t *nativeTray) convertToSubMenu(menuItemId uint32) (windows.Handle, error) {
 	const MIIM_SUBMENU = 0x00000004
 
 	res, _, err := pCreateMenu.Call()
@@ -539,13 +868,90 @@ func (t *winTray) convertToSubMenu(menuItemId uint32) (windows.Handle, error) {
 	return menu, nil
 }
 
-// SetRemovalAllowed sets whether a user can remove the systray icon or not.
+// menuSelected handles WM_MENUSELECT, sent when a menu item is highlighted
+// https://learn.microsoft.com/en-us/windows/win32/menurc/wm-menuselect
+func menuSelected(owner *Tray, wParam, lParam uintptr) {
+	const (
+		MF_POPUP     = 0x00000010
+		MF_SEPARATOR = 0x00000800
+	)
+	item, flags := uint32(wParam&0xFFFF), uint32(wParam>>16&0xFFFF)
+	if flags == 0xFFFF && lParam == 0 {
+		// the menu was closed
+		return
+	}
+	if flags&MF_SEPARATOR != 0 {
+		return
+	}
+	if flags&MF_POPUP != 0 {
+		// items opening a submenu are reported by position
+		t, parentId, ok := trayOfMenu(windows.Handle(lParam))
+		if !ok {
+			return
+		}
+		t.muVisibleItems.RLock()
+		visibleItems := t.visibleItems[parentId]
+		if int(item) >= len(visibleItems) {
+			t.muVisibleItems.RUnlock()
+			return
+		}
+		item = visibleItems[item]
+		t.muVisibleItems.RUnlock()
+	}
+	systrayMenuEvent(owner, item, currentMenuEvent(MenuEventHovered))
+}
+
+// currentMenuEvent returns an event of the kind for the message being processed
+func currentMenuEvent(kind MenuEventKind) MenuEvent {
+	// https://learn.microsoft.com/en-us/windows/win32/inputdev/virtual-key-codes
+	const (
+		VK_SHIFT   = 0x10
+		VK_CONTROL = 0x11
+		VK_MENU    = 0x12
+		VK_LWIN    = 0x5B
+		VK_RWIN    = 0x5C
+	)
+	pressed := func(key uintptr) bool {
+		state, _, _ := pGetKeyState.Call(key)
+		return state&0x8000 != 0
+	}
+
+	time, _, _ := pGetMessageTime.Call()
+	event := MenuEvent{Kind: kind, Timestamp: uint32(time)}
+	if pressed(VK_CONTROL) {
+		event.Modifiers |= ModifierControl
+	}
+	if pressed(VK_SHIFT) {
+		event.Modifiers |= ModifierShift
+	}
+	if pressed(VK_MENU) {
+		event.Modifiers |= ModifierAlt
+	}
+	if pressed(VK_LWIN) || pressed(VK_RWIN) {
+		event.Modifiers |= ModifierSuper
+	}
+	return event
+}
+
+// menuItemOf returns the ID of the menu item owning the submenu, 0 for the top level menu
+func (t *nativeTray) menuItemOf(submenu windows.Handle) (uint32, bool) {
+	t.muMenus.RLock()
+	defer t.muMenus.RUnlock()
+	for id, menu := range t.menus {
+		if menu == submenu {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// SetRemovalAllowed sets whether a user can remove the tray icon or not.
 // This is only supported on macOS.
-func SetRemovalAllowed(allowed bool) {
+func (t *Tray) SetRemovalAllowed(allowed bool) {
 }
 
-func (t *winTray) addOrUpdateMenuItem(menuItemId uint32, parentId uint32, title string, disabled, checked bool) error {
-	if !wt.isReady() {
+func (t *nativeTray) addOrUpdateMenuItem(menuItemId uint32, parentId uint32, title string, disabled, checked, radio bool) error {
+	if !t.isReady() {
 		return ErrTrayNotReadyYet
 	}
 
@@ -558,7 +964,10 @@ func (t *winTray) addOrUpdateMenuItem(menuItemId uint32, parentId uint32, title
 		MIIM_ID      = 0x00000002
 		MIIM_STATE   = 0x00000001
 	)
-	const MFT_STRING = 0x00000000
+	const (
+		MFT_STRING     = 0x00000000
+		MFT_RADIOCHECK = 0x00000200
+	)
 	const (
 		MFS_CHECKED  = 0x00000008
 		MFS_DISABLED = 0x00000003
@@ -582,13 +991,15 @@ func (t *winTray) addOrUpdateMenuItem(menuItemId uint32, parentId uint32, title
 	if checked {
 		mi.State |= MFS_CHECKED
 	}
+	if radio {
+		mi.Type |= MFT_RADIOCHECK
+	}
 	t.muMenuItemIcons.RLock()
 	hIcon := t.menuItemIcons[menuItemId]
 	t.muMenuItemIcons.RUnlock()
-	if hIcon > 0 {
-		mi.Mask |= MIIM_BITMAP
-		mi.BMPItem = hIcon
-	}
+	// always set, so that a removed icon is cleared
+	mi.Mask |= MIIM_BITMAP
+	mi.BMPItem = hIcon
 
 	var res uintptr
 	t.muMenus.RLock()
@@ -641,8 +1052,8 @@ func (t *winTray) addOrUpdateMenuItem(menuItemId uint32, parentId uint32, title
 	return nil
 }
 
-func (t *winTray) addSeparatorMenuItem(menuItemId, parentId uint32) error {
-	if !wt.isReady() {
+func (t *nativeTray) addSeparatorMenuItem(menuItemId, parentId uint32) error {
+	if !t.isReady() {
 		return ErrTrayNotReadyYet
 	}
 
@@ -680,8 +1091,8 @@ func (t *winTray) addSeparatorMenuItem(menuItemId, parentId uint32) error {
 	return nil
 }
 
-func (t *winTray) removeMenuItem(menuItemId, parentId uint32) error {
-	if !wt.isReady() {
+func (t *nativeTray) removeMenuItem(menuItemId, parentId uint32) error {
+	if !t.isReady() {
 		return ErrTrayNotReadyYet
 	}
 
@@ -704,8 +1115,8 @@ func (t *winTray) removeMenuItem(menuItemId, parentId uint32) error {
 	return nil
 }
 
-func (t *winTray) hideMenuItem(menuItemId, parentId uint32) error {
-	if !wt.isReady() {
+func (t *nativeTray) hideMenuItem(menuItemId, parentId uint32) error {
+	if !t.isReady() {
 		return ErrTrayNotReadyYet
 	}
 
@@ -728,8 +1139,8 @@ func (t *winTray) hideMenuItem(menuItemId, parentId uint32) error {
 	return nil
 }
 
-func (t *winTray) showMenu() error {
-	if !wt.isReady() {
+func (t *nativeTray) showMenu() error {
+	if !t.isReady() {
 		return ErrTrayNotReadyYet
 	}
 
@@ -760,7 +1171,7 @@ func (t *winTray) showMenu() error {
 	return nil
 }
 
-func (t *winTray) delFromVisibleItems(parent, val uint32) {
+func (t *nativeTray) delFromVisibleItems(parent, val uint32) {
 	t.muVisibleItems.Lock()
 	defer t.muVisibleItems.Unlock()
 	visibleItems := t.visibleItems[parent]
@@ -772,19 +1183,29 @@ func (t *winTray) delFromVisibleItems(parent, val uint32) {
 	}
 }
 
-func (t *winTray) addToVisibleItems(parent, val uint32) {
+func (t *nativeTray) addToVisibleItems(parent, val uint32) {
 	t.muVisibleItems.Lock()
 	defer t.muVisibleItems.Unlock()
 	if visibleItems, exists := t.visibleItems[parent]; !exists {
 		t.visibleItems[parent] = []uint32{val}
 	} else {
 		newvisible := append(visibleItems, val)
-		sort.Slice(newvisible, func(i, j int) bool { return newvisible[i] < newvisible[j] })
+		// keep the order of the menu, falling back to the order items were made visible in
+		indices := t.owner.menuIndices(parent)
+		index := func(id uint32) int {
+			if i, ok := indices[id]; ok {
+				return i
+			}
+			return -1
+		}
+		sort.SliceStable(newvisible, func(i, j int) bool {
+			return index(newvisible[i]) < index(newvisible[j])
+		})
 		t.visibleItems[parent] = newvisible
 	}
 }
 
-func (t *winTray) getVisibleItemIndex(parent, val uint32) int {
+func (t *nativeTray) getVisibleItemIndex(parent, val uint32) int {
 	t.muVisibleItems.RLock()
 	defer t.muVisibleItems.RUnlock()
 	for i, itemval := range t.visibleItems[parent] {
@@ -797,8 +1218,8 @@ func (t *winTray) getVisibleItemIndex(parent, val uint32) int {
 
 // Loads an image from file to be shown in tray or menu item.
 // LoadImage: https://msdn.microsoft.com/en-us/library/windows/desktop/ms648045(v=vs.85).aspx
-func (t *winTray) loadIconFrom(src string) (windows.Handle, error) {
-	if !wt.isReady() {
+func (t *nativeTray) loadIconFrom(src string) (windows.Handle, error) {
+	if !t.isReady() {
 		return 0, ErrTrayNotReadyYet
 	}
 
@@ -824,7 +1245,7 @@ func (t *winTray) loadIconFrom(src string) (windows.Handle, error) {
 			LR_LOADFROMFILE|LR_DEFAULTSIZE,
 		)
 		if res == 0 {
-			return 0, err
+			return 0, fmt.Errorf("%w: %v", ErrInvalidIcon, err)
 		}
 		h = windows.Handle(res)
 		t.muLoadedImages.Lock()
@@ -860,6 +1281,59 @@ func iconToBitmap(hIcon windows.Handle) (windows.Handle, error) {
 	return windows.Handle(hMemBmp), nil
 }
 
+// Draws overlay over the bottom right quarter of base and returns the resulting icon.
+// CreateIconIndirect: https://learn.microsoft.com/en-us/windows/win32/api/winuser/nf-winuser-createiconindirect
+func compositeIcons(base, overlay windows.Handle) (windows.Handle, error) {
+	const SM_CXSMICON = 49
+	const SM_CYSMICON = 50
+	const DI_NORMAL = 0x3
+	hDC, _, err := pGetDC.Call(uintptr(0))
+	if hDC == 0 {
+		return 0, err
+	}
+	defer pReleaseDC.Call(uintptr(0), hDC)
+	hMemDC, _, err := pCreateCompatibleDC.Call(hDC)
+	if hMemDC == 0 {
+		return 0, err
+	}
+	defer pDeleteDC.Call(hMemDC)
+	cx, _, _ := pGetSystemMetrics.Call(SM_CXSMICON)
+	cy, _, _ := pGetSystemMetrics.Call(SM_CYSMICON)
+	hColorBmp, err := create32BitHBitmap(hMemDC, int32(cx), int32(cy))
+	if err != nil {
+		return 0, err
+	}
+	defer pDeleteObject.Call(hColorBmp)
+	// monochrome rows are WORD aligned, an all zero mask leaves the alpha channel in charge
+	maskBits := make([]byte, (cx+15)/16*2*cy)
+	hMaskBmp, _, err := pCreateBitmap.Call(cx, cy, 1, 1, uintptr(unsafe.Pointer(&maskBits[0])))
+	if hMaskBmp == 0 {
+		return 0, err
+	}
+	defer pDeleteObject.Call(hMaskBmp)
+
+	hOriginalBmp, _, _ := pSelectObject.Call(hMemDC, hColorBmp)
+	res, _, err := pDrawIconEx.Call(hMemDC, 0, 0, uintptr(base), cx, cy, 0, uintptr(0), DI_NORMAL)
+	if res != 0 {
+		res, _, err = pDrawIconEx.Call(hMemDC, cx/2, cy/2, uintptr(overlay), cx/2, cy/2, 0, uintptr(0), DI_NORMAL)
+	}
+	pSelectObject.Call(hMemDC, hOriginalBmp)
+	if res == 0 {
+		return 0, err
+	}
+
+	ii := iconInfo{
+		Icon:        1,
+		MaskBitmap:  windows.Handle(hMaskBmp),
+		ColorBitmap: windows.Handle(hColorBmp),
+	}
+	hIcon, _, err := pCreateIconIndirect.Call(uintptr(unsafe.Pointer(&ii)))
+	if hIcon == 0 {
+		return 0, err
+	}
+	return windows.Handle(hIcon), nil
+}
+
 // https://learn.microsoft.com/en-us/windows/win32/api/wingdi/nf-wingdi-createdibsection
 func create32BitHBitmap(hDC uintptr, cx, cy int32) (uintptr, error) {
 	const BI_RGB uint32 = 0
@@ -889,19 +1363,23 @@ func create32BitHBitmap(hDC uintptr, cx, cy int32) (uintptr, error) {
 	return hBitmap, nil
 }
 
-func registerSystray() {
+// nativeOptions holds the options of RunContext specific to Windows, there are none
+type nativeOptions struct{}
+
+func registerSystray(nativeOptions) error {
+	wt := defaultTray.native
 	if err := wt.initInstance(); err != nil {
-		log.Printf("systray error: unable to init instance: %s\n", err)
-		return
+		return fmt.Errorf("unable to init instance: %w", err)
 	}
 
 	if err := wt.createMenu(); err != nil {
-		log.Printf("systray error: unable to create menu: %s\n", err)
-		return
+		return fmt.Errorf("unable to create menu: %w", err)
 	}
 
 	wt.initialized.Store(true)
+	startTrays()
 	systrayReady()
+	return nil
 }
 
 var m = &struct {
@@ -918,6 +1396,11 @@ func nativeLoop() {
 	}
 }
 
+func nativeRun() error {
+	nativeLoop()
+	return nil
+}
+
 func nativeEnd() {
 }
 
@@ -937,7 +1420,7 @@ func doNativeTick() bool {
 	// https://msdn.microsoft.com/en-us/library/windows/desktop/ms644936(v=vs.85).aspx
 	switch int32(ret) {
 	case -1:
-		log.Printf("systray error: message loop failure: %s\n", err)
+		logError("message loop failure", "error", err)
 		return false
 	case 0:
 		return false
@@ -952,17 +1435,13 @@ func quit() {
 	const WM_CLOSE = 0x0010
 
 	pPostMessage.Call(
-		uintptr(wt.window),
+		uintptr(defaultTray.native.window),
 		WM_CLOSE,
 		0,
 		0,
 	)
 
-	wt.muNID.Lock()
-	if wt.nid != nil {
-		wt.nid.delete()
-	}
-	wt.muNID.Unlock()
+	deleteIcons()
 	runSystrayExit()
 }
 
@@ -982,71 +1461,269 @@ func iconBytesToFilePath(iconBytes []byte) (string, error) {
 	return iconFilePath, nil
 }
 
-// SetIcon sets the systray icon.
+// SetIcon sets the tray icon.
 // iconBytes should be the content of .ico for windows and .ico/.jpg/.png
 // for other platforms.
-func SetIcon(iconBytes []byte) {
+func (t *Tray) SetIcon(iconBytes []byte) {
+	logFailure("unable to set icon", t.TrySetIcon(iconBytes), "tray", t.id)
+}
+
+// TrySetIcon is like SetIcon but returns an error on failure.
+func (t *Tray) TrySetIcon(iconBytes []byte) error {
+	return currentBackend().setIcon(t, iconBytes)
+}
+
+func (nativeBackend) setIcon(t *Tray, iconBytes []byte) error {
+	iconFilePath, err := iconFileFor(iconBytes)
+	if err != nil {
+		return err
+	}
+	return t.native.setIcon(iconFilePath)
+}
+
+// iconFileFor checks that iconBytes are .ico data and returns the path of a file holding them.
+// Only the directory is checked, LoadImage decodes every format of entries.
+func iconFileFor(iconBytes []byte) (string, error) {
+	if err := checkICO(iconBytes); err != nil {
+		return "", err
+	}
 	iconFilePath, err := iconBytesToFilePath(iconBytes)
 	if err != nil {
-		log.Printf("systray error: unable to write icon data to temp file: %s\n", err)
-		return
+		return "", fmt.Errorf("unable to write icon data to temp file: %w", err)
 	}
-	if err := wt.setIcon(iconFilePath); err != nil {
-		log.Printf("systray error: unable to set icon: %s\n", err)
-		return
+	return iconFilePath, nil
+}
+
+// SetAttentionIcon sets the icon shown instead of the regular one while the status is
+// StatusNeedsAttention.
+// iconBytes should be the content of .ico for windows and .ico/.jpg/.png
+// for other platforms.
+func (t *Tray) SetAttentionIcon(iconBytes []byte) {
+	logFailure("unable to set attention icon", t.TrySetAttentionIcon(iconBytes), "tray", t.id)
+}
+
+// TrySetAttentionIcon is like SetAttentionIcon but returns an error on failure.
+func (t *Tray) TrySetAttentionIcon(iconBytes []byte) error {
+	return currentBackend().setAttentionIcon(t, iconBytes)
+}
+
+func (nativeBackend) setAttentionIcon(t *Tray, iconBytes []byte) error {
+	iconFilePath, err := iconFileFor(iconBytes)
+	if err != nil {
+		return err
 	}
+	return t.native.setAttentionIcon(iconFilePath)
+}
+
+// SetOverlayIcon sets a small icon, such as a badge, drawn over the bottom right
+// corner of the tray icon.
+// iconBytes should be the content of .ico for windows and .ico/.jpg/.png
+// for other platforms.
+func (t *Tray) SetOverlayIcon(iconBytes []byte) {
+	logFailure("unable to set overlay icon", t.TrySetOverlayIcon(iconBytes), "tray", t.id)
+}
+
+// TrySetOverlayIcon is like SetOverlayIcon but returns an error on failure.
+// Empty iconBytes remove the overlay icon.
+func (t *Tray) TrySetOverlayIcon(iconBytes []byte) error {
+	return currentBackend().setOverlayIcon(t, iconBytes)
 }
 
-// SetIconFromFilePath sets the systray icon from a file path.
+func (nativeBackend) setOverlayIcon(t *Tray, iconBytes []byte) error {
+	if len(iconBytes) == 0 {
+		return t.native.setOverlayIcon("")
+	}
+	iconFilePath, err := iconFileFor(iconBytes)
+	if err != nil {
+		return err
+	}
+	return t.native.setOverlayIcon(iconFilePath)
+}
+
+// ClearOverlayIcon removes the icon set by SetOverlayIcon.
+func (t *Tray) ClearOverlayIcon() {
+	logFailure("unable to clear overlay icon", t.TrySetOverlayIcon(nil), "tray", t.id)
+}
+
+// SetIconFromFilePath sets the tray icon from a file path.
 // iconFilePath should be the path to a .ico for windows and .ico/.jpg/.png for other platforms.
-func SetIconFromFilePath(iconFilePath string) error {
-	err := wt.setIcon(iconFilePath)
+func (t *Tray) SetIconFromFilePath(iconFilePath string) error {
+	iconBytes, err := os.ReadFile(iconFilePath)
 	if err != nil {
-		return fmt.Errorf("failed to set icon: %v", err)
+		return fmt.Errorf("failed to read icon file: %v", err)
+	}
+	if err := t.TrySetIcon(iconBytes); err != nil {
+		return fmt.Errorf("failed to set icon: %w", err)
 	}
 	return nil
 }
 
-// SetTemplateIcon sets the systray icon as a template icon (on macOS), falling back
+// SetTemplateIcon sets the tray icon as a template icon (on macOS), falling back
 // to a regular icon on other platforms.
 // templateIconBytes and iconBytes should be the content of .ico for windows and
 // .ico/.jpg/.png for other platforms.
-func SetTemplateIcon(templateIconBytes []byte, regularIconBytes []byte) {
-	SetIcon(regularIconBytes)
+func (t *Tray) SetTemplateIcon(templateIconBytes []byte, regularIconBytes []byte) {
+	logFailure("unable to set template icon", t.TrySetTemplateIcon(templateIconBytes, regularIconBytes), "tray", t.id)
 }
 
-// SetTitle sets the systray title, only available on Mac and Linux.
-func SetTitle(title string) {
+// TrySetTemplateIcon is like SetTemplateIcon but returns an error on failure.
+func (t *Tray) TrySetTemplateIcon(templateIconBytes []byte, regularIconBytes []byte) error {
+	return currentBackend().setTemplateIcon(t, templateIconBytes, regularIconBytes)
+}
+
+func (b nativeBackend) setTemplateIcon(t *Tray, templateIconBytes, regularIconBytes []byte) error {
+	return b.setIcon(t, regularIconBytes)
+}
+
+// SetIconName sets the tray icon by name from the desktop icon theme, only available on Linux.
+func (t *Tray) SetIconName(name string) {
+	logFailure("unable to set icon name", t.TrySetIconName(name), "tray", t.id)
+}
+
+// TrySetIconName is like SetIconName but returns an error on failure.
+func (t *Tray) TrySetIconName(name string) error {
+	return currentBackend().setIconName(t, name)
+}
+
+func (nativeBackend) setIconName(*Tray, string) error {
+	// do nothing
+	return nil
+}
+
+// SetAttentionIconName sets the icon shown while the status is StatusNeedsAttention by name
+// from the desktop icon theme, only available on Linux.
+func (t *Tray) SetAttentionIconName(name string) {
+	logFailure("unable to set attention icon name", t.TrySetAttentionIconName(name), "tray", t.id)
+}
+
+// TrySetAttentionIconName is like SetAttentionIconName but returns an error on failure.
+func (t *Tray) TrySetAttentionIconName(name string) error {
+	return currentBackend().setAttentionIconName(t, name)
+}
+
+func (nativeBackend) setAttentionIconName(*Tray, string) error {
+	// do nothing
+	return nil
+}
+
+// SetOverlayIconName sets the icon drawn over the tray icon by name from the desktop icon theme,
+// only available on Linux.
+func (t *Tray) SetOverlayIconName(name string) {
+	logFailure("unable to set overlay icon name", t.TrySetOverlayIconName(name), "tray", t.id)
+}
+
+// TrySetOverlayIconName is like SetOverlayIconName but returns an error on failure.
+func (t *Tray) TrySetOverlayIconName(name string) error {
+	return currentBackend().setOverlayIconName(t, name)
+}
+
+func (nativeBackend) setOverlayIconName(*Tray, string) error {
+	// do nothing
+	return nil
+}
+
+// SetIconThemePath adds a directory to the icon theme search path, only available on Linux.
+func (t *Tray) SetIconThemePath(dir string) {
+	logFailure("unable to set icon theme path", t.TrySetIconThemePath(dir), "tray", t.id)
+}
+
+// TrySetIconThemePath is like SetIconThemePath but returns an error on failure.
+func (t *Tray) TrySetIconThemePath(dir string) error {
+	return currentBackend().setIconThemePath(t, dir)
+}
+
+func (nativeBackend) setIconThemePath(*Tray, string) error {
+	// do nothing
+	return nil
+}
+
+// SetIconName sets the icon of a menu item by name from the desktop icon theme,
+// only available on Linux.
+func (item *MenuItem) SetIconName(name string) {
+	currentBackend().setMenuItemIconName(item, name)
+}
+
+func (nativeBackend) setMenuItemIconName(*MenuItem, string) {
 	// do nothing
 }
 
-func (item *MenuItem) parentId() uint32 {
-	if item.parent != nil {
-		return uint32(item.parent.id)
+// SetTitle sets the tray title, only available on Mac and Linux.
+func (t *Tray) SetTitle(title string) {
+	logFailure("unable to set title", t.TrySetTitle(title), "tray", t.id)
+}
+
+// TrySetTitle is like SetTitle but returns an error on failure.
+func (t *Tray) TrySetTitle(title string) error {
+	return currentBackend().setTitle(t, title)
+}
+
+func (nativeBackend) setTitle(*Tray, string) error {
+	// do nothing
+	return nil
+}
+
+// label returns the menu item text, with the shortcut after a tab so that it is right aligned.
+func (item *MenuItem) label() string {
+	if item.shortcutKey == "" {
+		return item.title
+	}
+
+	shortcut := ""
+	if item.shortcutModifiers&ModifierControl != 0 {
+		shortcut += "Ctrl+"
+	}
+	if item.shortcutModifiers&ModifierAlt != 0 {
+		shortcut += "Alt+"
+	}
+	if item.shortcutModifiers&ModifierShift != 0 {
+		shortcut += "Shift+"
+	}
+	if item.shortcutModifiers&ModifierSuper != 0 {
+		shortcut += "Win+"
 	}
-	return 0
+	return item.title + "\t" + shortcut + item.shortcutKey
 }
 
 // SetIcon sets the icon of a menu item. Only works on macOS and Windows.
 // iconBytes should be the content of .ico/.jpg/.png
 func (item *MenuItem) SetIcon(iconBytes []byte) {
-	iconFilePath, err := iconBytesToFilePath(iconBytes)
-	if err != nil {
-		log.Printf("systray error: unable to write icon data to temp file: %s\n", err)
-		return
-	}
+	logFailure("unable to set menu item icon", item.TrySetIcon(iconBytes), "item", item.id)
+}
+
+// TrySetIcon is like SetIcon but returns an error on failure.
+func (item *MenuItem) TrySetIcon(iconBytes []byte) error {
+	return currentBackend().setMenuItemIcon(item, iconBytes)
+}
 
-	err = item.SetIconFromFilePath(iconFilePath)
+func (nativeBackend) setMenuItemIcon(item *MenuItem, iconBytes []byte) error {
+	iconFilePath, err := iconFileFor(iconBytes)
 	if err != nil {
-		log.Printf("systray error: %s\n", err)
-		return
+		return err
 	}
+	return item.setIconFile(iconFilePath)
+}
+
+func (nativeBackend) clearMenuItemIcon(item *MenuItem) {
+	t := item.tray.native
+	t.muMenuItemIcons.Lock()
+	delete(t.menuItemIcons, uint32(item.id))
+	t.muMenuItemIcons.Unlock()
+	addOrUpdateMenuItem(item)
 }
 
 // SetIconFromFilePath sets the icon of a menu item from a file path.
 // iconFilePath should be the path to a .ico for windows and .ico/.jpg/.png for other platforms.
 func (item *MenuItem) SetIconFromFilePath(iconFilePath string) error {
-	h, err := wt.loadIconFrom(iconFilePath)
+	iconBytes, err := os.ReadFile(iconFilePath)
+	if err != nil {
+		return fmt.Errorf("failed to read icon file: %v", err)
+	}
+	return item.TrySetIcon(iconBytes)
+}
+
+// setIconFile sets the icon of a menu item from the .ico file at iconFilePath
+func (item *MenuItem) setIconFile(iconFilePath string) error {
+	h, err := item.tray.native.loadIconFrom(iconFilePath)
 	if err != nil {
 		return fmt.Errorf("unable to load icon from file: %s", err)
 	}
@@ -1055,32 +1732,65 @@ func (item *MenuItem) SetIconFromFilePath(iconFilePath string) error {
 	if err != nil {
 		return fmt.Errorf("unable to convert icon to bitmap: %s", err)
 	}
-	wt.muMenuItemIcons.Lock()
-	wt.menuItemIcons[uint32(item.id)] = h
-	wt.muMenuItemIcons.Unlock()
+	item.tray.native.muMenuItemIcons.Lock()
+	item.tray.native.menuItemIcons[uint32(item.id)] = h
+	item.tray.native.muMenuItemIcons.Unlock()
 
-	err = wt.addOrUpdateMenuItem(uint32(item.id), item.parentId(), item.title, item.disabled, item.checked)
+	err = item.tray.native.addOrUpdateMenuItem(uint32(item.id), item.parentId(), item.label(), item.disabled, item.checked, item.radioGroup != "")
 	if err != nil {
 		return fmt.Errorf("unable to addOrUpdateMenuItem: %s", err)
 	}
 	return nil
 }
 
-// SetTooltip sets the systray tooltip to display on mouse hover of the tray icon,
+// SetTooltip sets the tray tooltip to display on mouse hover of the tray icon,
 // only available on Mac and Windows.
-func SetTooltip(tooltip string) {
-	if err := wt.setTooltip(tooltip); err != nil {
-		log.Printf("systray error: unable to set tooltip: %s\n", err)
-		return
-	}
+func (t *Tray) SetTooltip(tooltip string) {
+	logFailure("unable to set tooltip", t.TrySetTooltip(tooltip), "tray", t.id)
+}
+
+// TrySetTooltip is like SetTooltip but returns an error on failure.
+func (t *Tray) TrySetTooltip(tooltip string) error {
+	return currentBackend().setTooltip(t, tooltip)
+}
+
+func (nativeBackend) setTooltip(t *Tray, tooltip string) error {
+	return t.native.setTooltip(tooltip)
+}
+
+// SetStatus sets the status of the tray icon.
+// On Windows a passive icon is hidden from the notification area.
+func (t *Tray) SetStatus(status Status) {
+	logFailure("unable to set status", t.TrySetStatus(status), "tray", t.id)
+}
+
+// TrySetStatus is like SetStatus but returns an error on failure.
+func (t *Tray) TrySetStatus(status Status) error {
+	return currentBackend().setStatus(t, status)
+}
+
+func (nativeBackend) setStatus(t *Tray, status Status) error {
+	return t.native.setStatus(status)
 }
 
 func addOrUpdateMenuItem(item *MenuItem) {
-	err := wt.addOrUpdateMenuItem(uint32(item.id), item.parentId(), item.title, item.disabled, item.checked)
+	err := item.tray.native.addOrUpdateMenuItem(uint32(item.id), item.parentId(), item.label(), item.disabled, item.checked, item.radioGroup != "")
 	if err != nil {
-		log.Printf("systray error: unable to addOrUpdateMenuItem: %s\n", err)
+		logError("unable to addOrUpdateMenuItem", "item", item.id, "error", err)
+		return
+	}
+	if item.aboutToShow == nil || item.tray.native.getVisibleItemIndex(item.parentId(), item.id) == -1 {
 		return
 	}
+	item.tray.native.muMenus.RLock()
+	_, exists := item.tray.native.menus[item.id]
+	item.tray.native.muMenus.RUnlock()
+	if !exists {
+		// an empty popup is needed to receive WM_INITMENUPOPUP before the first item is added
+		if _, err := item.tray.native.convertToSubMenu(item.id); err != nil {
+			logError("unable to convertToSubMenu", "item", item.id, "error", err)
+		}
+	}
 }
 
 // SetTemplateIcon sets the icon of a menu item as a template icon (on macOS). On Windows, it
@@ -1088,29 +1798,46 @@ func addOrUpdateMenuItem(item *MenuItem) {
 // templateIconBytes and regularIconBytes should be the content of .ico for windows and
 // .ico/.jpg/.png for other platforms.
 func (item *MenuItem) SetTemplateIcon(templateIconBytes []byte, regularIconBytes []byte) {
-	item.SetIcon(regularIconBytes)
+	logFailure("unable to set menu item template icon", item.TrySetTemplateIcon(templateIconBytes, regularIconBytes), "item", item.id)
 }
 
-func addSeparator(id uint32, parent uint32) {
-	err := wt.addSeparatorMenuItem(id, parent)
+// TrySetTemplateIcon is like SetTemplateIcon but returns an error on failure.
+func (item *MenuItem) TrySetTemplateIcon(templateIconBytes []byte, regularIconBytes []byte) error {
+	return currentBackend().setMenuItemTemplateIcon(item, templateIconBytes, regularIconBytes)
+}
+
+func (b nativeBackend) setMenuItemTemplateIcon(item *MenuItem, templateIconBytes, regularIconBytes []byte) error {
+	return b.setMenuItemIcon(item, regularIconBytes)
+}
+
+func (t *Tray) addSeparator(id uint32, parent uint32) {
+	err := t.native.addSeparatorMenuItem(id, parent)
+	if err != nil {
+		logError("unable to addSeparator", "item", id, "error", err)
+		return
+	}
+}
+
+func (t *Tray) removeSeparator(id uint32, parent uint32) {
+	err := t.native.removeMenuItem(id, parent)
 	if err != nil {
-		log.Printf("systray error: unable to addSeparator: %s\n", err)
+		logError("unable to removeSeparator", "item", id, "error", err)
 		return
 	}
 }
 
 func hideMenuItem(item *MenuItem) {
-	err := wt.hideMenuItem(uint32(item.id), item.parentId())
+	err := item.tray.native.hideMenuItem(uint32(item.id), item.parentId())
 	if err != nil {
-		log.Printf("systray error: unable to hideMenuItem: %s\n", err)
+		logError("unable to hideMenuItem", "item", item.id, "error", err)
 		return
 	}
 }
 
 func removeMenuItem(item *MenuItem) {
-	err := wt.removeMenuItem(uint32(item.id), item.parentId())
+	err := item.tray.native.removeMenuItem(uint32(item.id), item.parentId())
 	if err != nil {
-		log.Printf("systray error: unable to removeMenuItem: %s\n", err)
+		logError("unable to removeMenuItem", "item", item.id, "error", err)
 		return
 	}
 }
@@ -1119,29 +1846,196 @@ func showMenuItem(item *MenuItem) {
 	addOrUpdateMenuItem(item)
 }
 
-func resetMenu() {
-	_, _, _ = pDestroyMenu.Call(uintptr(wt.menus[0]))
-	wt.visibleItems = make(map[uint32][]uint32)
-	wt.menus = make(map[uint32]windows.Handle)
-	wt.menuOf = make(map[uint32]windows.Handle)
-	wt.menuItemIcons = make(map[uint32]windows.Handle)
-	wt.createMenu()
+func moveMenuItem(item *MenuItem) {
+	if item.tray.native.getVisibleItemIndex(item.parentId(), uint32(item.id)) == -1 {
+		// hidden items are placed when they are shown again
+		return
+	}
+	// remove and insert it again at the position matching the new order
+	hideMenuItem(item)
+	addOrUpdateMenuItem(item)
 }
 
-func systrayLeftClick() {
-	if fn := tappedLeft; fn != nil {
-		fn()
+func (nativeBackend) beginMenuBatch(*Tray)    {}
+func (nativeBackend) batchMenuItem(*MenuItem) {}
+func (nativeBackend) endMenuBatch(*Tray)      {}
+
+func (t *Tray) resetMenu() {
+	n := t.native
+	_, _, _ = pDestroyMenu.Call(uintptr(n.menus[0]))
+	n.initMenus()
+	n.createMenu()
+}
+
+func systrayLeftClick(t *Tray) {
+	if fn := t.tappedLeftHandler(); fn != nil {
+		p := point{}
+		pGetCursorPos.Call(uintptr(unsafe.Pointer(&p)))
+		fn(int(p.X), int(p.Y))
+		return
+	}
+
+	t.native.showMenu()
+}
+
+func systrayRightClick(t *Tray) {
+	if fn := t.tappedRightHandler(); fn != nil {
+		p := point{}
+		pGetCursorPos.Call(uintptr(unsafe.Pointer(&p)))
+		fn(int(p.X), int(p.Y))
+		return
+	}
+
+	t.native.showMenu()
+}
+
+func systrayMiddleClick(t *Tray) {
+	if fn := t.tappedMiddleHandler(); fn != nil {
+		p := point{}
+		pGetCursorPos.Call(uintptr(unsafe.Pointer(&p)))
+		fn(int(p.X), int(p.Y))
+	}
+}
+
+// The notification area does not forward the mouse wheel to the icons. While the cursor is
+// over the icon of a tray with a function set by SetOnScroll, a low-level mouse hook catches
+// the wheel instead, it removes itself once the cursor leaves the icon.
+var (
+	wheelHook     windows.Handle
+	wheelHookProc = windows.NewCallback(wheelHookCallback)
+	// wheelTray is the tray whose icon is under the cursor, within wheelRect
+	wheelTray *Tray
+	wheelRect rect
+	wheelLock sync.Mutex
+)
+
+// iconRect returns the bounding rectangle of the icon of the tray on the screen.
+// Shell_NotifyIconGetRect: https://learn.microsoft.com/en-us/windows/win32/api/shellapi/nf-shellapi-shell_notifyicongetrect
+func (t *nativeTray) iconRect() (rect, error) {
+	id := notifyIconIdentifier{Wnd: t.window, ID: t.iconId()}
+	id.Size = uint32(unsafe.Sizeof(id))
+	r := rect{}
+	res, _, _ := pShellNotifyIconGetRect.Call(
+		uintptr(unsafe.Pointer(&id)),
+		uintptr(unsafe.Pointer(&r)),
+	)
+	if res != 0 {
+		return r, fmt.Errorf("failed to get the icon rectangle: HRESULT %#x", uint32(res))
+	}
+	return r, nil
+}
+
+// startWheelHook hooks the mouse wheel while the cursor is over the icon of the tray, if it has
+// a scroll handler. It must be called from the thread running the message loop.
+// SetWindowsHookEx: https://learn.microsoft.com/en-us/windows/win32/api/winuser/nf-winuser-setwindowshookexw
+func startWheelHook(t *Tray) {
+	const WH_MOUSE_LL = 14
+	if t.scrollHandler() == nil {
+		return
+	}
+	r, err := t.native.iconRect()
+	if err != nil {
+		logError("failed to hook the mouse wheel", "tray", t.id, "error", err)
+		return
+	}
+
+	wheelLock.Lock()
+	defer wheelLock.Unlock()
+	wheelTray, wheelRect = t, r
+	if wheelHook != 0 {
+		return
+	}
+	h, _, err := pSetWindowsHookEx.Call(
+		uintptr(WH_MOUSE_LL),
+		wheelHookProc,
+		uintptr(t.native.instance),
+		0,
+	)
+	if h == 0 {
+		logError("failed to hook the mouse wheel", "tray", t.id, "error", err)
 		return
 	}
+	wheelHook = windows.Handle(h)
+}
 
-	wt.showMenu()
+// stopWheelHook removes the wheel hook. It must be called from the thread running the message loop.
+// UnhookWindowsHookEx: https://learn.microsoft.com/en-us/windows/win32/api/winuser/nf-winuser-unhookwindowshookex
+func stopWheelHook() {
+	wheelLock.Lock()
+	defer wheelLock.Unlock()
+	wheelTray = nil
+	if wheelHook != 0 {
+		pUnhookWindowsHookEx.Call(uintptr(wheelHook))
+		wheelHook = 0
+	}
 }
 
-func systrayRightClick() {
-	if fn := tappedRight; fn != nil {
-		fn()
+// forgetWheelTray stops reporting the wheel to the tray, the hook removes itself on the next
+// mouse event
+func forgetWheelTray(t *Tray) {
+	wheelLock.Lock()
+	if wheelTray == t {
+		wheelTray = nil
+	}
+	wheelLock.Unlock()
+}
+
+// wheelHookCallback is the LowLevelMouseProc of the wheel hook. It posts the wheel events over
+// the icon to the window, so that the hook returns quickly, and removes the hook once the
+// cursor leaves the icon.
+// https://learn.microsoft.com/en-us/windows/win32/winmsg/lowlevelmouseproc
+func wheelHookCallback(code int32, wParam uintptr, info *msllHookStruct) uintptr {
+	const (
+		HC_ACTION      = 0
+		WM_MOUSEWHEEL  = 0x020A
+		WM_MOUSEHWHEEL = 0x020E
+	)
+	next, _, _ := pCallNextHookEx.Call(0, uintptr(code), wParam, uintptr(unsafe.Pointer(info)))
+	if code != HC_ACTION {
+		return next
+	}
+
+	wheelLock.Lock()
+	t, r := wheelTray, wheelRect
+	wheelLock.Unlock()
+	p := info.Pt
+	if t == nil || p.X < r.Left || p.X >= r.Right || p.Y < r.Top || p.Y >= r.Bottom {
+		stopWheelHook()
+		return next
+	}
+	if wParam == WM_MOUSEWHEEL || wParam == WM_MOUSEHWHEEL {
+		// the high-order word of mouseData is the delta
+		delta := int16(info.MouseData >> 16)
+		n := t.native
+		pPostMessage.Call(
+			uintptr(n.window),
+			uintptr(n.wmSystrayScroll),
+			uintptr(n.iconId()),
+			scrollParam(int(delta), wParam == WM_MOUSEHWHEEL),
+		)
+	}
+	return next
+}
+
+// scrollParam packs a wheel delta and its orientation in the lParam of wmSystrayScroll
+func scrollParam(delta int, horizontal bool) uintptr {
+	p := uintptr(uint16(int16(delta)))
+	if horizontal {
+		p |= 1 << 16
+	}
+	return p
+}
+
+// systrayScroll calls the scroll handler of the tray with the delta and orientation packed in lParam
+func systrayScroll(t *Tray, lParam uintptr) {
+	fn := t.scrollHandler()
+	if fn == nil {
 		return
 	}
 
-	wt.showMenu()
+	orientation := OrientationVertical
+	if lParam>>16&1 != 0 {
+		orientation = OrientationHorizontal
+	}
+	fn(int(int16(uint16(lParam))), orientation)
 }
@@ -22,6 +22,8 @@ func TestBaseWindowsTray(t *testing.T) {
 
 	runtime.LockOSThread()
 
+	wt := defaultTray.native
+
 	if err := wt.initInstance(); err != nil {
 		t.Fatalf("initInstance failed: %s", err)
 	}
@@ -43,39 +45,52 @@ func TestBaseWindowsTray(t *testing.T) {
 		t.Errorf("SetIcon failed: %s", err)
 	}
 
-	var id atomic.Int32
-	err := wt.addOrUpdateMenuItem(id.Add(1), "Simple enabled", false, false)
+	if err := wt.setOverlayIcon(iconFilePath); err != nil {
+		t.Errorf("setOverlayIcon failed: %s", err)
+	}
+	if wt.compositeIcon == 0 {
+		t.Error("expected the overlay to be composited")
+	}
+	if err := wt.setOverlayIcon(""); err != nil {
+		t.Errorf("setOverlayIcon failed: %s", err)
+	}
+	if wt.compositeIcon != 0 {
+		t.Error("expected the composite icon to be destroyed with the overlay")
+	}
+
+	var id atomic.Uint32
+	err := wt.addOrUpdateMenuItem(id.Add(1), 0, "Simple enabled", false, false, false)
 	if err != nil {
 		t.Errorf("mergeMenuItem failed: %s", err)
 	}
-	err = wt.addOrUpdateMenuItem(id.Add(1), "Simple disabled", true, false)
+	err = wt.addOrUpdateMenuItem(id.Add(1), 0, "Simple disabled", true, false, false)
 	if err != nil {
 		t.Errorf("mergeMenuItem failed: %s", err)
 	}
-	err = wt.addSeparatorMenuItem(id.Add(1))
+	err = wt.addSeparatorMenuItem(id.Add(1), 0)
 	if err != nil {
 		t.Errorf("addSeparatorMenuItem failed: %s", err)
 	}
-	err = wt.addOrUpdateMenuItem(id.Add(1), "Simple checked enabled", false, true)
+	err = wt.addOrUpdateMenuItem(id.Add(1), 0, "Simple checked enabled", false, true, false)
 	if err != nil {
 		t.Errorf("mergeMenuItem failed: %s", err)
 	}
-	err = wt.addOrUpdateMenuItem(id.Add(1), "Simple checked disabled", true, true)
+	err = wt.addOrUpdateMenuItem(id.Add(1), 0, "Simple checked disabled", true, true, false)
 	if err != nil {
 		t.Errorf("mergeMenuItem failed: %s", err)
 	}
 
-	err = wt.hideMenuItem(1)
+	err = wt.hideMenuItem(1, 0)
 	if err != nil {
 		t.Errorf("hideMenuItem failed: %s", err)
 	}
 
-	err = wt.hideMenuItem(100)
+	err = wt.hideMenuItem(100, 0)
 	if err == nil {
 		t.Error("hideMenuItem failed: must return error on invalid item id")
 	}
 
-	err = wt.addOrUpdateMenuItem(2, "Simple disabled update", true, false)
+	err = wt.addOrUpdateMenuItem(2, 0, "Simple disabled update", true, false, false)
 	if err != nil {
 		t.Errorf("mergeMenuItem failed: %s", err)
 	}
@@ -131,3 +146,36 @@ func TestWindowsRun(t *testing.T) {
 
 	Run(onReady, onExit)
 }
+
+func TestWindowsScroll(t *testing.T) {
+	type scroll struct {
+		delta       int
+		orientation Orientation
+	}
+	scrolled := make(chan scroll, 2)
+	SetOnScroll(func(delta int, orientation Orientation) { scrolled <- scroll{delta, orientation} })
+	defer SetOnScroll(nil)
+
+	systrayScroll(defaultTray, scrollParam(-120, false))
+	systrayScroll(defaultTray, scrollParam(240, true))
+	if got := <-scrolled; got != (scroll{-120, OrientationVertical}) {
+		t.Errorf("unexpected scroll %+v", got)
+	}
+	if got := <-scrolled; got != (scroll{240, OrientationHorizontal}) {
+		t.Errorf("unexpected scroll %+v", got)
+	}
+}
+
+func TestWindowsStatusBeforeReady(t *testing.T) {
+	tray := newTray(100, nil, nil)
+	n := tray.native
+	if err := n.setStatus(StatusPassive); err != nil {
+		t.Fatalf("setStatus failed before the icon is added: %s", err)
+	}
+
+	// without a window the icon cannot be added, the status is applied to it regardless
+	n.addIcon()
+	if n.nid.State != 1 {
+		t.Errorf("expected the passive icon to be added hidden, got the state %d", n.nid.State)
+	}
+}
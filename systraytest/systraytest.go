@@ -0,0 +1,385 @@
+// Package systraytest provides a fake backend for the systray package, so that applications
+// can test their trays without a desktop, on a CI server for instance. The fake backend
+// records the icons, title, tooltip, status and menus set by the application, and tests can
+// click menu items, open menus, tap the icon and scroll over it, then check the resulting state.
+//
+// The fake backend replaces the platform one for the whole process, tests using it cannot
+// run in parallel.
+package systraytest
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"fyne.io/systray"
+	"fyne.io/systray/internal/fake"
+)
+
+// Item is the recorded state of a menu item or a separator.
+type Item struct {
+	// Separator is true for separators, other fields are then empty.
+	Separator bool
+
+	Title     string
+	Tooltip   string
+	Icon      []byte
+	IconName  string
+	Disabled  bool
+	Hidden    bool
+	Checkable bool
+	Checked   bool
+	// Items are the entries of the sub-menu of the item.
+	Items []Item
+
+	id uint32
+}
+
+// Tray gives access to a tray of the application during a test.
+type Tray struct {
+	tb testing.TB
+	// tray is nil for the default tray
+	tray *systray.Tray
+}
+
+// Run starts the fake backend in place of the platform one, as systray.Run would, and returns
+// the default tray once onReady returned. At the end of the test, the fake backend quits
+// unless systray.Quit was called, which calls onExit, then the trays are reset for the next
+// test: the trays added with systray.NewTray are removed and the menus are emptied.
+func Run(tb testing.TB, onReady, onExit func()) *Tray {
+	tb.Helper()
+	if err := fake.Start(onReady, onExit); err != nil {
+		tb.Fatalf("systraytest: %v, tests using it cannot run in parallel", err)
+	}
+	tb.Cleanup(fake.Stop)
+	return &Tray{tb: tb}
+}
+
+// Wrap gives access to a tray added with systray.NewTray during a test started by Run.
+func Wrap(tb testing.TB, tray *systray.Tray) *Tray {
+	return &Tray{tb: tb, tray: tray}
+}
+
+// QuitCalled returns whether systray.Quit was called since Run.
+func QuitCalled() bool {
+	return fake.Quitted()
+}
+
+// Icon returns the icon set by SetIcon or a related function, nil if none.
+// SetTemplateIcon records the regular icon.
+func (t *Tray) Icon() []byte {
+	return t.state().Icon
+}
+
+// AttentionIcon returns the icon set by SetAttentionIcon, nil if none.
+func (t *Tray) AttentionIcon() []byte {
+	return t.state().AttentionIcon
+}
+
+// OverlayIcon returns the icon set by SetOverlayIcon, nil if none or once it is cleared.
+func (t *Tray) OverlayIcon() []byte {
+	return t.state().OverlayIcon
+}
+
+// IconName returns the name set by SetIconName.
+func (t *Tray) IconName() string {
+	return t.state().IconName
+}
+
+// AttentionIconName returns the name set by SetAttentionIconName.
+func (t *Tray) AttentionIconName() string {
+	return t.state().AttentionIconName
+}
+
+// OverlayIconName returns the name set by SetOverlayIconName.
+func (t *Tray) OverlayIconName() string {
+	return t.state().OverlayIconName
+}
+
+// IconThemePath returns the directory set by SetIconThemePath.
+func (t *Tray) IconThemePath() string {
+	return t.state().IconThemePath
+}
+
+// Status returns the status set by SetStatus.
+func (t *Tray) Status() systray.Status {
+	return systray.Status(t.state().Status)
+}
+
+// Title returns the title set by SetTitle.
+func (t *Tray) Title() string {
+	return t.state().Title
+}
+
+// Tooltip returns the tooltip set by SetTooltip.
+func (t *Tray) Tooltip() string {
+	return t.state().Tooltip
+}
+
+// Hidden returns whether the icon is hidden by Hide.
+func (t *Tray) Hidden() bool {
+	return t.state().Hidden
+}
+
+// MenuOpen returns whether the top level menu is open, after OpenMenu or a tap without a
+// handler and until CloseMenu.
+func (t *Tray) MenuOpen() bool {
+	return t.state().MenuOpen
+}
+
+// Menu returns the entries of the menu of the tray.
+func (t *Tray) Menu() []Item {
+	return itemsOf(t.state().Menu)
+}
+
+// Item returns the menu item found by following the titles of path from the top level menu,
+// the test fails if there is none.
+func (t *Tray) Item(path ...string) Item {
+	t.tb.Helper()
+	item, err := find(t.Menu(), path)
+	if err != nil {
+		t.tb.Fatalf("systraytest: %v", err)
+	}
+	return item
+}
+
+// Click clicks the menu item found by following the titles of path, the test fails if it is
+// missing, hidden or disabled, along with the items leading to it.
+// It returns once the functions set with MenuItem.OnClick returned, goroutines receiving from
+// ClickedCh may still be handling the click.
+func (t *Tray) Click(path ...string) {
+	t.tb.Helper()
+	if len(path) == 0 {
+		t.tb.Fatal("systraytest: no menu item to click")
+	}
+	item := t.reachable(path)
+	fake.Event(t.tray, item.id, int(systray.MenuEventClicked))
+}
+
+// OpenMenu opens the sub-menu of the item found by following the titles of path, or the top
+// level menu when path is empty, notifying OpenedCh and the function set with
+// MenuItem.SetOnAboutToShow. The test fails if the item is missing, hidden or disabled.
+func (t *Tray) OpenMenu(path ...string) {
+	t.tb.Helper()
+	t.menuEvent(path, systray.MenuEventOpened)
+}
+
+// CloseMenu closes the sub-menu of the item found by following the titles of path, or the top
+// level menu when path is empty, notifying ClosedCh.
+func (t *Tray) CloseMenu(path ...string) {
+	t.tb.Helper()
+	t.menuEvent(path, systray.MenuEventClosed)
+}
+
+// Tap clicks the icon with the primary button, calling the function set with SetOnTapped or
+// SetOnTappedAt with a position of 0, 0. As on most platforms, the menu is opened if there is
+// none.
+func (t *Tray) Tap() {
+	t.tb.Helper()
+	t.TapAt(0, 0)
+}
+
+// TapAt clicks the icon with the primary button at the position, calling the function set with
+// SetOnTapped or SetOnTappedAt. The menu is opened if there is none.
+func (t *Tray) TapAt(x, y int) {
+	t.tb.Helper()
+	if !fake.Tap(t.tray, fake.Primary, x, y) {
+		t.OpenMenu()
+	}
+}
+
+// SecondaryTap clicks the icon with the secondary button, calling the function set with
+// SetOnSecondaryTapped or SetOnSecondaryTappedAt with a position of 0, 0. The menu is opened
+// if there is none.
+func (t *Tray) SecondaryTap() {
+	t.tb.Helper()
+	t.SecondaryTapAt(0, 0)
+}
+
+// SecondaryTapAt clicks the icon with the secondary button at the position, calling the
+// function set with SetOnSecondaryTapped or SetOnSecondaryTappedAt. The menu is opened if there
+// is none.
+func (t *Tray) SecondaryTapAt(x, y int) {
+	t.tb.Helper()
+	if !fake.Tap(t.tray, fake.Secondary, x, y) {
+		t.OpenMenu()
+	}
+}
+
+// MiddleTap clicks the icon with the middle button, calling the function set with
+// SetOnMiddleTapped or SetOnMiddleTappedAt with a position of 0, 0. Nothing happens if there is
+// none.
+func (t *Tray) MiddleTap() {
+	t.MiddleTapAt(0, 0)
+}
+
+// MiddleTapAt clicks the icon with the middle button at the position, calling the function set
+// with SetOnMiddleTapped or SetOnMiddleTappedAt. Nothing happens if there is none.
+func (t *Tray) MiddleTapAt(x, y int) {
+	fake.Tap(t.tray, fake.Middle, x, y)
+}
+
+// Scroll scrolls over the icon, calling the function set with SetOnScroll. Nothing happens if
+// there is none.
+func (t *Tray) Scroll(delta int, orientation systray.Orientation) {
+	fake.Scroll(t.tray, delta, int(orientation))
+}
+
+// AssertTitle reports an error if the title of the tray is not want.
+func (t *Tray) AssertTitle(want string) {
+	t.tb.Helper()
+	if got := t.Title(); got != want {
+		t.tb.Errorf("systraytest: expected the title %q, got %q", want, got)
+	}
+}
+
+// AssertTooltip reports an error if the tooltip of the tray is not want.
+func (t *Tray) AssertTooltip(want string) {
+	t.tb.Helper()
+	if got := t.Tooltip(); got != want {
+		t.tb.Errorf("systraytest: expected the tooltip %q, got %q", want, got)
+	}
+}
+
+// AssertIcon reports an error if the icon of the tray is not want.
+func (t *Tray) AssertIcon(want []byte) {
+	t.tb.Helper()
+	if got := t.Icon(); !bytes.Equal(got, want) {
+		t.tb.Errorf("systraytest: expected an icon of %d bytes, got another one of %d bytes", len(want), len(got))
+	}
+}
+
+// AssertChecked reports an error if the menu item found by following the titles of path is
+// not checked, or is checked when want is false.
+func (t *Tray) AssertChecked(want bool, path ...string) {
+	t.tb.Helper()
+	if got := t.Item(path...).Checked; got != want {
+		t.tb.Errorf("systraytest: expected %s checked to be %t", strings.Join(path, " > "), want)
+	}
+}
+
+// AssertDisabled reports an error if the menu item found by following the titles of path is
+// not disabled, or is disabled when want is false.
+func (t *Tray) AssertDisabled(want bool, path ...string) {
+	t.tb.Helper()
+	if got := t.Item(path...).Disabled; got != want {
+		t.tb.Errorf("systraytest: expected %s disabled to be %t", strings.Join(path, " > "), want)
+	}
+}
+
+// AssertMenu reports an error if the menu of the tray does not match want, as written by
+// MenuString. Leading and trailing line breaks of want are ignored.
+func (t *Tray) AssertMenu(want string) {
+	t.tb.Helper()
+	want = strings.Trim(want, "\n")
+	if got := t.MenuString(); got != want {
+		t.tb.Errorf("systraytest: unexpected menu\nexpected:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+// MenuString writes the menu of the tray with one entry per line, sub-menus being indented by
+// two spaces. Separators are written as "---", checkable items start with "[x] " or "[ ] " and
+// " (disabled)" or " (hidden)" follow the title of items in such states.
+func (t *Tray) MenuString() string {
+	var b strings.Builder
+	writeMenu(&b, t.Menu(), 0)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func (t *Tray) state() fake.Tray {
+	return fake.State(t.tray)
+}
+
+func (t *Tray) menuEvent(path []string, kind systray.MenuEventKind) {
+	t.tb.Helper()
+	var id uint32
+	if len(path) > 0 {
+		id = t.reachable(path).id
+	}
+	fake.Event(t.tray, id, int(kind))
+}
+
+// reachable returns the item found by following the titles of path, the test fails if a user
+// could not reach it
+func (t *Tray) reachable(path []string) Item {
+	t.tb.Helper()
+	items := t.Menu()
+	var item Item
+	for i := range path {
+		var err error
+		if item, err = find(items, path[i:i+1]); err != nil {
+			t.tb.Fatalf("systraytest: %v", err)
+		}
+		switch {
+		case item.Hidden:
+			t.tb.Fatalf("systraytest: menu item %q is hidden", path[i])
+		case item.Disabled:
+			t.tb.Fatalf("systraytest: menu item %q is disabled", path[i])
+		}
+		items = item.Items
+	}
+	return item
+}
+
+// find returns the item found by following the titles of path from items
+func find(items []Item, path []string) (Item, error) {
+	if len(path) == 0 {
+		return Item{}, fmt.Errorf("no menu item given")
+	}
+	for _, item := range items {
+		if item.Separator || item.Title != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			return item, nil
+		}
+		return find(item.Items, path[1:])
+	}
+	return Item{}, fmt.Errorf("no menu item %q", path[0])
+}
+
+func itemsOf(recorded []fake.Item) []Item {
+	items := make([]Item, len(recorded))
+	for i, r := range recorded {
+		items[i] = Item{
+			Separator: r.Separator,
+			Title:     r.Title,
+			Tooltip:   r.Tooltip,
+			Icon:      r.Icon,
+			IconName:  r.IconName,
+			Disabled:  r.Disabled,
+			Hidden:    r.Hidden,
+			Checkable: r.Checkable,
+			Checked:   r.Checked,
+			Items:     itemsOf(r.Items),
+			id:        r.ID,
+		}
+	}
+	return items
+}
+
+func writeMenu(b *strings.Builder, items []Item, depth int) {
+	for _, item := range items {
+		b.WriteString(strings.Repeat("  ", depth))
+		if item.Separator {
+			b.WriteString("---\n")
+			continue
+		}
+		switch {
+		case item.Checked:
+			b.WriteString("[x] ")
+		case item.Checkable:
+			b.WriteString("[ ] ")
+		}
+		b.WriteString(item.Title)
+		if item.Disabled {
+			b.WriteString(" (disabled)")
+		}
+		if item.Hidden {
+			b.WriteString(" (hidden)")
+		}
+		b.WriteString("\n")
+		writeMenu(b, item.Items, depth+1)
+	}
+}
@@ -0,0 +1,207 @@
+package systraytest
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"fyne.io/systray"
+)
+
+func TestMenu(t *testing.T) {
+	var opened, clicked int
+	tray := Run(t, func() {
+		systray.SetTitle("App")
+		systray.SetTooltip("Tooltip")
+		notify := systray.AddMenuItemCheckbox("Notify", "", true)
+		notify.OnClick(func() {
+			if notify.Checked() {
+				notify.Uncheck()
+			} else {
+				notify.Check()
+			}
+		})
+		systray.AddMenuItemRadio("profile", "Work", "").Check()
+		systray.AddMenuItemRadio("profile", "Home", "")
+		systray.AddSeparator()
+		recent := systray.AddMenuItem("Recent", "")
+		recent.SetOnAboutToShow(func() {
+			opened++
+			recent.AddSubMenuItem("File", "").OnClick(func() { clicked++ })
+		})
+		systray.AddMenuItem("Update", "").Disable()
+	}, nil)
+
+	tray.AssertTitle("App")
+	tray.AssertTooltip("Tooltip")
+	tray.AssertMenu(`
+[x] Notify
+[x] Work
+[ ] Home
+---
+Recent
+Update (disabled)
+`)
+
+	tray.Click("Notify")
+	tray.AssertChecked(false, "Notify")
+	tray.Click("Home")
+	tray.AssertChecked(false, "Work")
+	tray.AssertChecked(true, "Home")
+	tray.AssertDisabled(true, "Update")
+
+	tray.OpenMenu("Recent")
+	if opened != 1 {
+		t.Errorf("expected the sub-menu to be built once, got %d", opened)
+	}
+	tray.Click("Recent", "File")
+	if clicked != 1 {
+		t.Errorf("expected one click on the sub-menu item, got %d", clicked)
+	}
+	if item := tray.Item("Recent"); len(item.Items) != 1 || item.Items[0].Title != "File" {
+		t.Errorf("unexpected sub-menu %v", item.Items)
+	}
+}
+
+func TestTap(t *testing.T) {
+	var tapped bool
+	tray := Run(t, func() {
+		systray.SetOnTapped(func() { tapped = true })
+	}, nil)
+
+	tray.Tap()
+	if !tapped {
+		t.Error("expected the function set with SetOnTapped to be called")
+	}
+
+	tray.SecondaryTap()
+	if !tray.MenuOpen() {
+		t.Fatal("expected the menu to be opened without a secondary tap handler")
+	}
+	tray.CloseMenu()
+	if tray.MenuOpen() {
+		t.Error("expected the menu to be closed")
+	}
+}
+
+func TestTapAtAndScroll(t *testing.T) {
+	var secondary, middle [2]int
+	var delta int
+	var orientation systray.Orientation
+	tray := Run(t, func() {
+		systray.SetOnSecondaryTappedAt(func(x, y int) { secondary = [2]int{x, y} })
+		systray.SetOnMiddleTappedAt(func(x, y int) { middle = [2]int{x, y} })
+		systray.SetOnScroll(func(d int, o systray.Orientation) { delta, orientation = d, o })
+	}, nil)
+
+	tray.SecondaryTapAt(12, 34)
+	if secondary != [2]int{12, 34} {
+		t.Errorf("expected a secondary tap at 12, 34, got %v", secondary)
+	}
+	tray.MiddleTapAt(56, 78)
+	if middle != [2]int{56, 78} {
+		t.Errorf("expected a middle tap at 56, 78, got %v", middle)
+	}
+	tray.Scroll(-3, systray.OrientationHorizontal)
+	if delta != -3 || orientation != systray.OrientationHorizontal {
+		t.Errorf("expected a horizontal scroll of -3, got %d %v", delta, orientation)
+	}
+	if tray.MenuOpen() {
+		t.Error("expected the menu to stay closed")
+	}
+}
+
+func TestIcons(t *testing.T) {
+	icon := solidPNG(t)
+	tray := Run(t, func() {
+		systray.SetStatus(systray.StatusNeedsAttention)
+		systray.SetAttentionIcon(icon)
+		systray.SetOverlayIcon(icon)
+		systray.SetIconName("mail-unread")
+		systray.SetAttentionIconName("dialog-warning")
+		systray.SetOverlayIconName("emblem-important")
+		systray.AddMenuItem("Inbox", "").SetIconName("mail-inbox")
+	}, nil)
+
+	if tray.Status() != systray.StatusNeedsAttention {
+		t.Errorf("unexpected status %v", tray.Status())
+	}
+	if !bytes.Equal(tray.AttentionIcon(), icon) || !bytes.Equal(tray.OverlayIcon(), icon) {
+		t.Error("expected the attention and overlay icons to be recorded")
+	}
+	if name := tray.IconName(); name != "mail-unread" {
+		t.Errorf("unexpected icon name %q", name)
+	}
+	if tray.AttentionIconName() != "dialog-warning" || tray.OverlayIconName() != "emblem-important" {
+		t.Errorf("unexpected attention and overlay icon names %q and %q", tray.AttentionIconName(), tray.OverlayIconName())
+	}
+	if name := tray.Item("Inbox").IconName; name != "mail-inbox" {
+		t.Errorf("unexpected menu item icon name %q", name)
+	}
+
+	systray.ClearOverlayIcon()
+	if tray.OverlayIcon() != nil {
+		t.Error("expected the overlay icon to be cleared")
+	}
+	if err := systray.TrySetAttentionIcon([]byte("not an image")); !errors.Is(err, systray.ErrInvalidIcon) {
+		t.Errorf("expected ErrInvalidIcon, got %v", err)
+	}
+}
+
+// solidPNG returns a 1x1 white PNG image
+func solidPNG(t *testing.T) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, 1, 1))
+	img.Set(0, 0, color.White)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode failed: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestQuit(t *testing.T) {
+	exited := 0
+	t.Run("run", func(t *testing.T) {
+		tray := Run(t, func() { systray.AddMenuItem("Quit", "").OnClick(systray.Quit) }, func() { exited++ })
+		tray.Click("Quit")
+		if !QuitCalled() {
+			t.Error("expected Quit to be called")
+		}
+		tray.AssertMenu("")
+	})
+	if exited != 1 {
+		t.Errorf("expected onExit to be called once, got %d", exited)
+	}
+
+	t.Run("cleanup", func(t *testing.T) {
+		tray := Run(t, nil, func() { exited++ })
+		if QuitCalled() {
+			t.Error("expected a new run not to be quitted")
+		}
+		tray.AssertMenu("")
+	})
+	if exited != 2 {
+		t.Errorf("expected onExit to be called at the end of the test, got %d calls", exited)
+	}
+}
+
+func TestHide(t *testing.T) {
+	var added *systray.Tray
+	tray := Run(t, func() {
+		systray.Hide()
+		added = systray.NewTray()
+		added.SetTitle("Added")
+	}, nil)
+
+	if !tray.Hidden() {
+		t.Error("expected the default tray to be hidden")
+	}
+	other := Wrap(t, added)
+	other.AssertTitle("Added")
+	if other.Hidden() {
+		t.Error("expected the added tray to be shown")
+	}
+}